@@ -33,22 +33,305 @@ type HelmChartSpec struct {
 
 	// +kubebuilder:pruning:PreserveUnknownFields
 	Values runtime.RawExtension `json:"values,omitempty"`
+
+	// Timeout is the time to wait for the chart resources to become ready, defaults to 5m
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// Interval is the period to reconcile the HelmChart with a 10% jitter,
+	// it is reconciled on the manager-wide resync period if not set
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// HistoryLimit is the number of revisions to keep, defaults to 10
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	HistoryLimit *int32 `json:"historyLimit,omitempty"`
+
+	// RollbackTo pins the HelmChart to a stored revision instead of rendering the chart,
+	// the annotation app.siji.io/rollback-to does the same thing
+	// +optional
+	RollbackTo int64 `json:"rollbackTo,omitempty"`
+
+	// Upgrade is the upgrade configuration
+	// +optional
+	Upgrade *Upgrade `json:"upgrade,omitempty"`
+
+	// Suspend stops rendering and applying the chart, the deletion is still handled
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// DriftPolicy is the action taken when the resources are changed outside of the HelmChart, defaults to Correct
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+
+	// DryRun only plans the changes with server side dry-run, nothing in the cluster is changed
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Approval requires new revisions to be approved before applying
+	// +optional
+	Approval *Approval `json:"approval,omitempty"`
+
+	// CRDs is the policy of the CRDs in the chart
+	// +optional
+	CRDs *CRDs `json:"crds,omitempty"`
+
+	// DeletionPolicy is the action on the resources when the HelmChart is deleted, defaults to Delete
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Uninstall is the options of deleting the resources tracked by HelmDog
+	// +optional
+	Uninstall *Uninstall `json:"uninstall,omitempty"`
+}
+
+type Uninstall struct {
+	// GracePeriod is the time to wait for the finalizers of a deleted resource before removing them, defaults to 5m
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+
+	// FinalizerPolicies overrides the action on the finalizers of the resources by kind
+	// +optional
+	FinalizerPolicies []FinalizerPolicy `json:"finalizerPolicies,omitempty"`
+
+	// Timeout is the time to wait for the resources to be deleted before releasing the HelmChart,
+	// defaults to the longest grace period plus 1m, or no timeout if any finalizer policy is Wait
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// DeletionPolicy is the action on the resources when the HelmChart is deleted
+// +kubebuilder:validation:Enum=Delete;Orphan
+type DeletionPolicy string
+
+const (
+	// DeletionDelete deletes the resources of the HelmChart
+	DeletionDelete DeletionPolicy = "Delete"
+	// DeletionOrphan keeps the resources and removes the owner references and tracking of them
+	DeletionOrphan DeletionPolicy = "Orphan"
+)
+
+type Approval struct {
+	// Required makes a new revision pending until it is approved
+	// +optional
+	Required bool `json:"required,omitempty"`
+
+	// Digest approves the pending revision whose digest is the same,
+	// the annotation app.siji.io/approve does the same thing
+	// +optional
+	Digest string `json:"digest,omitempty"`
 }
 
+// DriftPolicy is the action taken on drifted resources
+// +kubebuilder:validation:Enum=Correct;Warn
+type DriftPolicy string
+
+const (
+	// DriftCorrect reverts the drifted resources
+	DriftCorrect DriftPolicy = "Correct"
+	// DriftWarn only reports the drifted resources, they are still updated when the chart changes them
+	DriftWarn DriftPolicy = "Warn"
+)
+
+type CRDs struct {
+	// Apply is the action on the CRDs when installing or upgrading, defaults to CreateReplace
+	// +optional
+	Apply CRDApplyPolicy `json:"apply,omitempty"`
+
+	// Uninstall is the action on the CRDs when uninstalling, defaults to Keep
+	// +optional
+	Uninstall CRDUninstallPolicy `json:"uninstall,omitempty"`
+}
+
+// CRDApplyPolicy is the action on the CRDs when installing or upgrading
+// +kubebuilder:validation:Enum=Skip;Create;CreateReplace
+type CRDApplyPolicy string
+
+const (
+	// CRDSkip does not apply the CRDs, they must be installed by others
+	CRDSkip CRDApplyPolicy = "Skip"
+	// CRDCreate creates the CRDs which do not exist, the existing ones are not updated
+	CRDCreate CRDApplyPolicy = "Create"
+	// CRDCreateReplace creates or updates the CRDs
+	CRDCreateReplace CRDApplyPolicy = "CreateReplace"
+)
+
+// CRDUninstallPolicy is the action on the CRDs when uninstalling
+// +kubebuilder:validation:Enum=Keep;Delete
+type CRDUninstallPolicy string
+
+const (
+	// CRDKeep keeps the CRDs and the custom resources of them
+	CRDKeep CRDUninstallPolicy = "Keep"
+	// CRDDelete deletes the CRDs, all the custom resources of them are deleted too
+	CRDDelete CRDUninstallPolicy = "Delete"
+)
+
 type Chart struct {
 	Path     string `json:"path"`
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
 }
 
+type Upgrade struct {
+	// Remediation is the action taken when applying a new revision keeps failing,
+	// the failed revision is retried forever if it is not set
+	// +optional
+	Remediation *Remediation `json:"remediation,omitempty"`
+
+	// Recreate deletes and recreates the resources when the changed fields are immutable,
+	// it can be overridden by the annotation app.siji.io/recreate on a resource
+	// +optional
+	Recreate bool `json:"recreate,omitempty"`
+}
+
+// RemediationStrategy is the action taken after the remediation retries are exhausted
+// +kubebuilder:validation:Enum=Rollback;None
+type RemediationStrategy string
+
+const (
+	// RemediationRollback re-applies the last successfully applied revision
+	RemediationRollback RemediationStrategy = "Rollback"
+	// RemediationNone leaves the resources as they are and stops retrying
+	RemediationNone RemediationStrategy = "None"
+)
+
+type Remediation struct {
+	// Retries is the number of retries before remediating, defaults to 3
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Retries *int32 `json:"retries,omitempty"`
+
+	// Strategy is the action taken after the retries are exhausted, defaults to Rollback
+	// +optional
+	Strategy RemediationStrategy `json:"strategy,omitempty"`
+}
+
 // HelmChartStatus defines the observed state of HelmChart
 type HelmChartStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// ObservedGeneration is the last generation reconciled by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions is the latest observations of the HelmChart state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Phase is Terminating while the resources of a deleted HelmChart are being deleted
+	// +optional
+	Phase HelmChartPhase `json:"phase,omitempty"`
+
+	// Revision is the currently applied revision
+	// +optional
+	Revision int64 `json:"revision,omitempty"`
+
+	// History is the stored revisions, the oldest first
+	// +optional
+	History []Revision `json:"history,omitempty"`
+
+	// LastAttemptedDigest is the digest of the last manifests tried to apply
+	// +optional
+	LastAttemptedDigest string `json:"lastAttemptedDigest,omitempty"`
+
+	// InputDigest is the digest of the chart, values and operator version of the current revision,
+	// the chart is not rendered again while it is unchanged
+	// +optional
+	InputDigest string `json:"inputDigest,omitempty"`
+
+	// Failures is the number of consecutive failures of applying the last attempted manifests
+	// +optional
+	Failures int32 `json:"failures,omitempty"`
+
+	// Drift is the resources changed outside of the HelmChart
+	// +optional
+	Drift []DriftedResource `json:"drift,omitempty"`
+
+	// Plan is the summary of the changes to apply, the diff is stored in a ConfigMap
+	// +optional
+	Plan *Plan `json:"plan,omitempty"`
+
+	// PendingRevision is the revision waiting for approval
+	// +optional
+	PendingRevision *Plan `json:"pendingRevision,omitempty"`
 }
 
+// HelmChartPhase is the lifecycle phase of a HelmChart
+type HelmChartPhase string
+
+const (
+	// PhaseTerminating means the HelmChart is waiting for its resources to be deleted
+	PhaseTerminating HelmChartPhase = "Terminating"
+)
+
+// Plan is the result of a dry-run
+type Plan struct {
+	// Digest is the digest of the planned manifests
+	Digest       string      `json:"digest"`
+	ChartVersion string      `json:"chartVersion,omitempty"`
+	ValuesHash   string      `json:"valuesHash"`
+	Added        int32       `json:"added"`
+	Changed      int32       `json:"changed"`
+	Removed      int32       `json:"removed"`
+	ConfigMap    string      `json:"configMap"`
+	PlannedAt    metav1.Time `json:"plannedAt"`
+}
+
+// DriftedResource is a resource whose live state is different from the applied one
+type DriftedResource struct {
+	Resource `json:",inline"`
+
+	// Fields is the paths of the drifted fields
+	Fields []string `json:"fields"`
+}
+
+// Revision is a successfully applied set of manifests, the manifests are stored in a Secret
+type Revision struct {
+	Revision     int64       `json:"revision"`
+	ChartVersion string      `json:"chartVersion,omitempty"`
+	ValuesHash   string      `json:"valuesHash"`
+	Digest       string      `json:"digest"`
+	AppliedAt    metav1.Time `json:"appliedAt"`
+}
+
+const (
+	// ReadyCondition indicates whether all the chart resources are healthy
+	ReadyCondition = "Ready"
+	// RemediatedCondition indicates a failed upgrade is remediated
+	RemediatedCondition = "Remediated"
+	// SuspendedCondition indicates the reconciliation is suspended
+	SuspendedCondition = "Suspended"
+	// ApprovedCondition indicates whether the desired revision is approved
+	ApprovedCondition = "Approved"
+	// ConflictCondition indicates some fields of the resources are managed by others
+	ConflictCondition = "Conflict"
+)
+
+const (
+	ReasonProgressing = "Progressing"
+	ReasonHealthy     = "Healthy"
+	ReasonTimeout     = "Timeout"
+	ReasonFailed      = "Failed"
+	ReasonRollback    = "Rollback"
+	ReasonRetries     = "RetriesExhausted"
+	ReasonSuspended   = "Suspended"
+	ReasonPending     = "Pending"
+	ReasonApproved    = "Approved"
+	ReasonConflict    = "FieldConflict"
+	ReasonTerminating = "Terminating"
+	ReasonSynced      = "Synced"
+	ReasonDeleting    = "Deleting"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Revision",type="integer",JSONPath=".status.revision"
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // HelmChart is the Schema for the helmcharts API
 type HelmChart struct {
@@ -81,7 +81,13 @@ func (h *validatingHandler) Handle(ctx context.Context, req admission.Request) a
 	userInfo := req.UserInfo
 
 	if req.Operation == admissionv1.Create || req.Operation == admissionv1.Update {
-		manifests, err := helm.GetManifests(helmChart.Name, helmChart.Namespace, helmChart.Spec.Chart.Path, helmChart.Spec.Values.Raw)
+		chart, err := helm.GetChart(helmChart.Spec.Chart.Path)
+		if err != nil {
+			log.Error(err, "failed to get Helm chart")
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		manifests, err := helm.RenderManifests(helmChart.Name, helmChart.Namespace, chart, helmChart.Spec.Values.Raw)
 		if err != nil {
 			log.Error(err, "failed to get Helm manifests")
 			return admission.Errored(http.StatusBadRequest, err)
@@ -114,7 +120,8 @@ func (h *validatingHandler) Handle(ctx context.Context, req admission.Request) a
 			},
 
 			Data: map[string][]byte{
-				"manifests": manifestsBytes,
+				"manifests":    manifestsBytes,
+				"chartVersion": []byte(chart.Metadata.Version),
 			},
 		}
 
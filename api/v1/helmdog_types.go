@@ -30,6 +30,10 @@ type Resource struct {
 	Kind      string `json:"kind"`
 	Name      string `json:"name"`
 	Namespace string `json:"namespace,omitempty"`
+
+	// UID is the UID of the applied object, a recreated object with a different UID is not deleted
+	// +optional
+	UID string `json:"uid,omitempty"`
 }
 
 // HelmDogSpec defines the desired state of HelmDog
@@ -39,6 +43,77 @@ type HelmDogSpec struct {
 
 	// Resources is the resources that to be deleted when uninstall a helm chart
 	Resources []Resource `json:"resources"`
+
+	// CRDPolicy is the action on the CRDs in Resources when the HelmDog is deleted, defaults to Keep
+	// +optional
+	CRDPolicy CRDUninstallPolicy `json:"crdPolicy,omitempty"`
+
+	// GracePeriod is the time to wait for the finalizers of a deleted resource before removing them, defaults to 5m
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+
+	// FinalizerPolicies overrides the action on the finalizers of the resources by kind
+	// +optional
+	FinalizerPolicies []FinalizerPolicy `json:"finalizerPolicies,omitempty"`
+}
+
+// FinalizerPolicy is the action on the finalizers of the deleted resources of a kind
+type FinalizerPolicy struct {
+	Group string `json:"group,omitempty"`
+	Kind  string `json:"kind"`
+
+	// Action is the action on the finalizers
+	Action FinalizerAction `json:"action"`
+
+	// GracePeriod overrides the grace period of the kind for Remove
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// FinalizerAction is the action on the finalizers of a deleted resource
+// +kubebuilder:validation:Enum=Remove;Wait
+type FinalizerAction string
+
+const (
+	// FinalizerRemove removes the finalizers after the grace period
+	FinalizerRemove FinalizerAction = "Remove"
+	// FinalizerWait waits until the finalizers are removed by their controllers
+	FinalizerWait FinalizerAction = "Wait"
+)
+
+// ResourcePhase is the state of a resource tracked by HelmDog
+type ResourcePhase string
+
+const (
+	// ResourcePresent means the resource exists and is tracked
+	ResourcePresent ResourcePhase = "Present"
+	// ResourceDeleting means the resource is deleted and waiting for its finalizers
+	ResourceDeleting ResourcePhase = "Deleting"
+	// ResourceDeleted means the resource does not exist
+	ResourceDeleted ResourcePhase = "Deleted"
+	// ResourceKept means the resource is not deleted because of the annotations or the CRD policy
+	ResourceKept ResourcePhase = "Kept"
+	// ResourceFailed means the resource failed to be checked or deleted
+	ResourceFailed ResourcePhase = "Failed"
+)
+
+// ResourceState is the state of a resource tracked by HelmDog
+type ResourceState struct {
+	Resource `json:",inline"`
+
+	Phase ResourcePhase `json:"phase"`
+
+	// Message is the reason of the phase, like the error of a Failed resource
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Finalizers is the finalizers of a Deleting resource
+	// +optional
+	Finalizers []string `json:"finalizers,omitempty"`
+
+	// DeletedAt is the deletion timestamp of a Deleting resource
+	// +optional
+	DeletedAt *metav1.Time `json:"deletedAt,omitempty"`
 }
 
 // HelmDogStatus defines the observed state of HelmDog
@@ -48,6 +123,14 @@ type HelmDogStatus struct {
 
 	// Resources is the current resources in HelmDog
 	Resources []Resource `json:"resources"`
+
+	// Conditions is the latest observations of the HelmDog state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ResourceStates is the state of the tracked and the deleted resources
+	// +optional
+	ResourceStates []ResourceState `json:"resourceStates,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,242 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/pointer"
+	"github.com/chenzhiwei/helm-operator/utils/yaml"
+)
+
+// applyState collects the results of applying the manifests of a release
+type applyState struct {
+	// lastApplied is the manifests of the current revision by object key
+	lastApplied map[string][]byte
+
+	// resources is the cluster scoped or other namespaces resources to be tracked by HelmDog
+	resources []appv1.Resource
+	// objects is the live objects after applying, used for health check
+	objects []*unstructured.Unstructured
+	// drift is the resources changed outside of the HelmChart
+	drift []appv1.DriftedResource
+	// conflicts is the resources not applied because of the fields managed by others
+	conflicts []string
+
+	// driftOnly applies the resources only when they are drifted
+	driftOnly bool
+}
+
+// applyManifests applies the consecutive manifests of the same kind concurrently, the groups are applied
+// in order and it stops at the first group with failures
+func (r *HelmChartReconciler) applyManifests(ctx context.Context, cr *appv1.HelmChart, st *applyState, manifests [][]byte) error {
+	groups, err := groupByKind(manifests)
+	if err != nil {
+		return err
+	}
+
+	concurrency := r.ApplyConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	for _, group := range groups {
+		states := make([]*applyState, len(group))
+		errs := make([]error, len(group))
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, concurrency)
+		for i, m := range group {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, m []byte) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				states[i] = &applyState{lastApplied: st.lastApplied, driftOnly: st.driftOnly}
+				errs[i] = r.applyManifest(ctx, cr, states[i], m)
+			}(i, m)
+		}
+		wg.Wait()
+
+		// merge the results in the manifests order
+		for _, s := range states {
+			st.resources = append(st.resources, s.resources...)
+			st.objects = append(st.objects, s.objects...)
+			st.drift = append(st.drift, s.drift...)
+			st.conflicts = append(st.conflicts, s.conflicts...)
+		}
+
+		if err := utilerrors.NewAggregate(errs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// groupByKind groups the consecutive manifests of the same kind
+func groupByKind(manifests [][]byte) ([][][]byte, error) {
+	var groups [][][]byte
+	var last schema.GroupKind
+	for i, m := range manifests {
+		obj, err := yaml.YamlToObject(m)
+		if err != nil {
+			return nil, err
+		}
+
+		gk := obj.GroupVersionKind().GroupKind()
+		if i == 0 || gk != last {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], m)
+		last = gk
+	}
+
+	return groups, nil
+}
+
+func (r *HelmChartReconciler) applyManifest(ctx context.Context, cr *appv1.HelmChart, st *applyState, m []byte) error {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	obj, err := yaml.YamlToObject(m)
+	if err != nil {
+		r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventApplyFailed, "failed to parse manifest: %s", err)
+		return err
+	}
+	last, applied := st.lastApplied[objectKey(obj)]
+
+	if err := r.prepareObject(cr, obj); err != nil {
+		r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventApplyFailed, "failed to prepare %s %s: %s", obj.GetKind(), obj.GetName(), err)
+		return fmt.Errorf("failed to prepare %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+
+	// store the cluster scoped and shared resources for cleanResources, with the UID of the live object if it is known
+	track := func(live *unstructured.Unstructured) {
+		if !trackedByHelmDog(cr, obj) {
+			return
+		}
+		res := r.trackedResource(obj)
+		if live != nil {
+			res.UID = string(live.GetUID())
+		}
+		st.resources = append(st.resources, res)
+	}
+
+	if applied {
+		live, fields, err := r.detectDrift(ctx, obj, last)
+		if err != nil {
+			log.Error(err, "failed to detect drift", "kind", obj.GetKind(), "name", obj.GetName(), "namespace", obj.GetNamespace())
+		} else if len(fields) > 0 {
+			st.drift = append(st.drift, appv1.DriftedResource{
+				Resource: resourceOf(obj),
+				Fields:   fields,
+			})
+			r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventDriftDetected, "%s %s drifted: %s", obj.GetKind(), obj.GetName(), strings.Join(fields, ", "))
+
+			// only warn on the drift unless the chart changes the object
+			if getDriftPolicy(cr) == appv1.DriftWarn && bytes.Equal(m, last) {
+				track(live)
+				if live != nil {
+					st.objects = append(st.objects, live)
+				}
+				return nil
+			}
+			r.Recorder.Eventf(cr, corev1.EventTypeNormal, eventDriftCorrected, "%s %s is reverted", obj.GetKind(), obj.GetName())
+		} else if st.driftOnly && live != nil && isTracked(obj, live) {
+			track(live)
+			st.objects = append(st.objects, live)
+			return nil
+		}
+	}
+
+	log.Info("creating Helm manifest", "kind", obj.GetKind(), "name", obj.GetName(), "namespace", obj.GetNamespace())
+	start := time.Now()
+	patchOptions := &client.PatchOptions{
+		FieldManager: helmChartFieldManager(cr),
+	}
+	desired := obj.DeepCopy()
+	err = r.Patch(ctx, obj, client.Apply, patchOptions)
+	if managers := conflictManagers(err); len(managers) > 0 {
+		// the object of another HelmChart is not tracked, so it is not deleted with this HelmChart
+		if owners := conflictOwners(managers); len(owners) > 0 {
+			applyDuration.WithLabelValues(obj.GetKind(), applyConflict).Observe(time.Since(start).Seconds())
+			conflict := fmt.Sprintf("%s is managed by %s", objectName(obj), strings.Join(owners, ", "))
+			log.Info("skip applying the conflicting manifest", "kind", obj.GetKind(), "name", obj.GetName(), "managers", owners)
+			r.Recorder.Event(cr, corev1.EventTypeWarning, eventConflict, conflict)
+			st.conflicts = append(st.conflicts, conflict)
+			return nil
+		}
+
+		if taken := takenOverManagers(managers); len(taken) > 0 {
+			log.Info("take over the conflicting fields", "kind", obj.GetKind(), "name", obj.GetName(), "managers", taken)
+			r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventConflict, "%s: took over the fields managed by %s", objectName(obj), strings.Join(taken, ", "))
+		}
+		patchOptions.Force = pointer.Bool(true)
+		err = r.Patch(ctx, obj, client.Apply, patchOptions)
+	}
+	if errors.IsInvalid(err) && obj.GetNamespace() == cr.Namespace && !isShared(obj) {
+		owner, getErr := r.controllingHelmChart(ctx, cr, obj)
+		if getErr != nil {
+			log.Error(getErr, "failed to get the controller of the object", "kind", obj.GetKind(), "name", obj.GetName())
+		}
+		if owner != "" {
+			applyDuration.WithLabelValues(obj.GetKind(), applyConflict).Observe(time.Since(start).Seconds())
+			conflict := fmt.Sprintf("%s is controlled by HelmChart %s", objectName(obj), owner)
+			log.Info("skip applying the manifest controlled by another HelmChart", "kind", obj.GetKind(), "name", obj.GetName(), "owner", owner)
+			r.Recorder.Event(cr, corev1.EventTypeWarning, eventConflict, conflict)
+			st.conflicts = append(st.conflicts, conflict)
+			return nil
+		}
+	}
+	if err != nil && isImmutableError(err) && recreateEnabled(cr, obj) {
+		err = r.recreate(ctx, cr, obj, patchOptions)
+	}
+	if err == nil {
+		// apply again after migrating the legacy field manager to prune the removed fields
+		var migrated bool
+		if migrated, err = r.migrateFieldManager(ctx, cr, obj); err == nil && migrated {
+			log.Info("migrated the legacy field manager", "kind", obj.GetKind(), "name", obj.GetName())
+			obj = desired.DeepCopy()
+			err = r.Patch(ctx, obj, client.Apply, patchOptions)
+		}
+	}
+	if err != nil {
+		applyDuration.WithLabelValues(obj.GetKind(), applyFailed).Observe(time.Since(start).Seconds())
+		r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventApplyFailed, "failed to apply %s %s: %s", obj.GetKind(), obj.GetName(), err)
+		return fmt.Errorf("failed to apply %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	applyDuration.WithLabelValues(obj.GetKind(), applySucceeded).Observe(time.Since(start).Seconds())
+	track(obj)
+	st.objects = append(st.objects, obj)
+
+	return nil
+}
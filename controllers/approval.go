@@ -0,0 +1,99 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/constant"
+)
+
+// approve returns the release to apply when the approval is required. It is the desired release if
+// it is approved or not changed, otherwise it is the current revision while the desired one is pending,
+// or nil if there is no current revision.
+func (r *HelmChartReconciler) approve(ctx context.Context, cr *appv1.HelmChart, rel *release) (*release, error) {
+	if cr.Spec.Approval == nil || !cr.Spec.Approval.Required {
+		cr.Status.PendingRevision = nil
+		meta.RemoveStatusCondition(&cr.Status.Conditions, appv1.ApprovedCondition)
+		return rel, nil
+	}
+
+	dgst := rel.digest()
+	if dgst == currentDigest(cr) || dgst == approvedDigest(cr) {
+		cr.Status.PendingRevision = nil
+		meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
+			Type:               appv1.ApprovedCondition,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: cr.Generation,
+			Reason:             appv1.ReasonApproved,
+			Message:            "the desired revision is approved",
+		})
+		return rel, nil
+	}
+
+	pending := cr.Status.PendingRevision
+	if pending == nil || pending.Digest != dgst {
+		plan, err := r.plan(ctx, cr, rel)
+		if err != nil {
+			return nil, err
+		}
+		pending = plan
+		r.Recorder.Eventf(cr, corev1.EventTypeNormal, eventApprovalPending, "revision %s is waiting for approval: %d to add, %d to change, %d to remove",
+			dgst, plan.Added, plan.Changed, plan.Removed)
+	}
+	cr.Status.PendingRevision = pending
+
+	meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
+		Type:               appv1.ApprovedCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: cr.Generation,
+		Reason:             appv1.ReasonPending,
+		Message:            fmt.Sprintf("set spec.approval.digest or annotation %s to %s to approve", constant.ApproveAnnotation, dgst),
+	})
+
+	if cr.Status.Revision == 0 {
+		return nil, nil
+	}
+
+	// keep the current revision in place while waiting
+	return r.loadRevision(ctx, cr, cr.Status.Revision)
+}
+
+// currentDigest returns the digest of the current revision
+func currentDigest(cr *appv1.HelmChart) string {
+	for _, h := range cr.Status.History {
+		if h.Revision == cr.Status.Revision {
+			return h.Digest
+		}
+	}
+
+	return ""
+}
+
+func approvedDigest(cr *appv1.HelmChart) string {
+	if cr.Spec.Approval.Digest != "" {
+		return cr.Spec.Approval.Digest
+	}
+
+	return cr.GetAnnotations()[constant.ApproveAnnotation]
+}
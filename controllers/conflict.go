@@ -0,0 +1,208 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+)
+
+const (
+	// the field manager is limited to 128 characters
+	maxFieldManagerLength = 128
+
+	helmChartManagerPrefix = "helmchart/"
+)
+
+// the message of a field manager conflict cause is like: conflict with "kubectl" using apps/v1
+var conflictManagerRegexp = regexp.MustCompile(`conflict with "([^"]*)"`)
+
+// helmChartFieldManager returns the field manager of the resources applied by a HelmChart
+func helmChartFieldManager(cr *appv1.HelmChart) string {
+	manager := helmChartManagerPrefix + cr.Namespace + "/" + cr.Name
+	if len(manager) > maxFieldManagerLength {
+		manager = helmChartManagerPrefix + digest([]byte(cr.Namespace+"/"+cr.Name))
+	}
+
+	return manager
+}
+
+// conflictManagers returns the field managers in a server side apply conflict error
+func conflictManagers(err error) []string {
+	if !errors.IsConflict(err) {
+		return nil
+	}
+
+	status, ok := err.(errors.APIStatus)
+	if !ok || status.Status().Details == nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	var managers []string
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		match := conflictManagerRegexp.FindStringSubmatch(cause.Message)
+		if match == nil || seen[match[1]] {
+			continue
+		}
+		seen[match[1]] = true
+		managers = append(managers, match[1])
+	}
+	sort.Strings(managers)
+
+	return managers
+}
+
+// conflictOwners returns the managers whose fields must not be overwritten by the HelmChart.
+// The fields of other HelmCharts are never overwritten, the fields of other managers are taken over,
+// because the object is applied only when the drift is corrected or the chart changes it.
+func conflictOwners(managers []string) []string {
+	var owners []string
+	for _, m := range managers {
+		if strings.HasPrefix(m, helmChartManagerPrefix) {
+			owners = append(owners, m)
+		}
+	}
+
+	return owners
+}
+
+// takenOverManagers returns the managers whose fields are overwritten by the HelmChart
+func takenOverManagers(managers []string) []string {
+	var result []string
+	for _, m := range managers {
+		if m != fieldManager && !strings.HasPrefix(m, helmChartManagerPrefix) {
+			result = append(result, m)
+		}
+	}
+
+	return result
+}
+
+// controllingHelmChart returns the other HelmChart controlling the live object, two HelmCharts in the
+// same namespace rendering the same object can not both be its controller
+func (r *HelmChartReconciler) controllingHelmChart(ctx context.Context, cr *appv1.HelmChart, obj *unstructured.Unstructured) (string, error) {
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(obj.GroupVersionKind())
+	if err := r.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, live); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+
+	owner := metav1.GetControllerOf(live)
+	if owner == nil || owner.UID == cr.UID || owner.Kind != "HelmChart" ||
+		!strings.HasPrefix(owner.APIVersion, appv1.GroupVersion.Group+"/") {
+		return "", nil
+	}
+
+	return live.GetNamespace() + "/" + owner.Name, nil
+}
+
+// migrateFieldManager moves the fields applied by the legacy field manager to the field manager of
+// the HelmChart, so the fields removed from the chart are pruned. It returns true if the managed
+// fields of the object are changed.
+func (r *HelmChartReconciler) migrateFieldManager(ctx context.Context, cr *appv1.HelmChart, obj *unstructured.Unstructured) (bool, error) {
+	manager := helmChartFieldManager(cr)
+
+	var result, legacy []metav1.ManagedFieldsEntry
+	for _, e := range obj.GetManagedFields() {
+		if e.Manager == fieldManager && e.Operation == metav1.ManagedFieldsOperationApply {
+			legacy = append(legacy, e)
+		} else {
+			result = append(result, e)
+		}
+	}
+	if len(legacy) == 0 {
+		return false, nil
+	}
+
+	for _, e := range legacy {
+		// the fields of another API version can not be merged, they are released
+		for i := range result {
+			own := &result[i]
+			if own.Manager != manager || own.Operation != metav1.ManagedFieldsOperationApply ||
+				own.APIVersion != e.APIVersion || own.FieldsV1 == nil || e.FieldsV1 == nil {
+				continue
+			}
+			fields, err := unionFields(own.FieldsV1, e.FieldsV1)
+			if err != nil {
+				return false, err
+			}
+			own.FieldsV1 = fields
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"managedFields":   result,
+			"resourceVersion": obj.GetResourceVersion(),
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, r.Patch(ctx, obj.DeepCopy(), client.RawPatch(types.MergePatchType, patch))
+}
+
+func unionFields(a, b *metav1.FieldsV1) (*metav1.FieldsV1, error) {
+	sa, sb := &fieldpath.Set{}, &fieldpath.Set{}
+	if err := sa.FromJSON(bytes.NewReader(a.Raw)); err != nil {
+		return nil, err
+	}
+	if err := sb.FromJSON(bytes.NewReader(b.Raw)); err != nil {
+		return nil, err
+	}
+
+	raw, err := sa.Union(sb).ToJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return &metav1.FieldsV1{Raw: raw}, nil
+}
+
+func setConflictStatus(cr *appv1.HelmChart, conflicts []string) {
+	if len(conflicts) == 0 {
+		meta.RemoveStatusCondition(&cr.Status.Conditions, appv1.ConflictCondition)
+		return
+	}
+
+	meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
+		Type:               appv1.ConflictCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: cr.Generation,
+		Reason:             appv1.ReasonConflict,
+		Message:            strings.Join(conflicts, "; "),
+	})
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestConflictManagers(t *testing.T) {
+	conflict := func(messages ...string) error {
+		var causes []metav1.StatusCause
+		for _, m := range messages {
+			causes = append(causes, metav1.StatusCause{Type: metav1.CauseTypeFieldManagerConflict, Message: m, Field: ".spec.replicas"})
+		}
+		return errors.NewApplyConflict(causes, "Apply failed with conflicts")
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{
+			name: "no error",
+		},
+		{
+			name: "not a conflict",
+			err:  errors.NewNotFound(schema.GroupResource{Resource: "deployments"}, "test"),
+		},
+		{
+			name: "other error",
+			err:  fmt.Errorf("conflict with \"kubectl\""),
+		},
+		{
+			name: "single manager",
+			err:  conflict(`conflict with "kubectl-edit" using apps/v1`),
+			want: []string{"kubectl-edit"},
+		},
+		{
+			name: "sorted and deduplicated managers",
+			err: conflict(
+				`conflict with "kubectl-edit" using apps/v1`,
+				`conflict with "helmchart/default/nginx" using apps/v1`,
+				`conflict with "kubectl-edit" using apps/v1`,
+			),
+			want: []string{"helmchart/default/nginx", "kubectl-edit"},
+		},
+		{
+			name: "unknown message",
+			err:  conflict("something else"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := conflictManagers(tt.err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("conflictManagers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConflictOwners(t *testing.T) {
+	managers := []string{fieldManager, "helmchart/default/nginx", "kubectl-edit"}
+
+	if got, want := conflictOwners(managers), []string{"helmchart/default/nginx"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("conflictOwners() = %v, want %v", got, want)
+	}
+	if got, want := takenOverManagers(managers), []string{"kubectl-edit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("takenOverManagers() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/yaml"
+)
+
+const crdEstablishTimeout = 30 * time.Second
+
+func isCRD(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	return gvk.Group == "apiextensions.k8s.io" && gvk.Kind == "CustomResourceDefinition"
+}
+
+// splitCRDs splits the CRDs from the other manifests, the order is kept
+func splitCRDs(manifests [][]byte) ([][]byte, [][]byte, error) {
+	var crds, others [][]byte
+	for _, m := range manifests {
+		obj, err := yaml.YamlToObject(m)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if isCRD(obj) {
+			crds = append(crds, m)
+		} else {
+			others = append(others, m)
+		}
+	}
+
+	return crds, others, nil
+}
+
+// applyCRDs applies the CRDs by the CRD apply policy and returns the CRDs to wait for
+func (r *HelmChartReconciler) applyCRDs(ctx context.Context, cr *appv1.HelmChart, st *applyState, crds [][]byte) ([][]byte, error) {
+	policy := getCRDApplyPolicy(cr)
+	if policy == appv1.CRDCreateReplace {
+		return crds, r.applyManifests(ctx, cr, st, crds)
+	}
+
+	for _, m := range crds {
+		obj, err := yaml.YamlToObject(m)
+		if err != nil {
+			return nil, err
+		}
+
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(obj.GroupVersionKind())
+		if err := r.Get(ctx, types.NamespacedName{Name: obj.GetName()}, live); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, err
+			}
+			if policy == appv1.CRDCreate {
+				if err := r.applyManifest(ctx, cr, st, m); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		// keep tracking the CRD created by this chart, so it is not pruned and can be deleted on uninstall
+		if managedBy(live, helmChartFieldManager(cr)) || managedBy(live, fieldManager) {
+			res := r.trackedResource(obj)
+			res.UID = string(live.GetUID())
+			st.resources = append(st.resources, res)
+		}
+	}
+
+	if policy == appv1.CRDSkip {
+		return nil, nil
+	}
+
+	return crds, nil
+}
+
+// waitForCRDs waits until the CRDs are established and their kinds are known by the RESTMapper,
+// so the custom resources in the same chart can be applied
+func (r *HelmChartReconciler) waitForCRDs(ctx context.Context, crds [][]byte) error {
+	if len(crds) == 0 {
+		return nil
+	}
+
+	for _, m := range crds {
+		obj, err := yaml.YamlToObject(m)
+		if err != nil {
+			return err
+		}
+
+		crd := &unstructured.Unstructured{}
+		crd.SetGroupVersionKind(obj.GroupVersionKind())
+		err = wait.PollImmediateWithContext(ctx, time.Second, crdEstablishTimeout, func(ctx context.Context) (bool, error) {
+			if err := r.Get(ctx, types.NamespacedName{Name: obj.GetName()}, crd); err != nil {
+				return false, client.IgnoreNotFound(err)
+			}
+			return crdEstablished(crd), nil
+		})
+		if err != nil {
+			return fmt.Errorf("CRD %s is not established: %w", obj.GetName(), err)
+		}
+	}
+
+	// the RESTMapper caches the discovery result
+	mapper := r.Client.RESTMapper()
+	if resettable, ok := mapper.(meta.ResettableRESTMapper); ok {
+		resettable.Reset()
+	}
+
+	for _, m := range crds {
+		obj, err := yaml.YamlToObject(m)
+		if err != nil {
+			return err
+		}
+
+		group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+		gk := schema.GroupKind{Group: group, Kind: kind}
+
+		// the dynamic RESTMapper reloads on no match errors, but the reloading is rate limited
+		err = wait.PollImmediateWithContext(ctx, time.Second, crdEstablishTimeout, func(ctx context.Context) (bool, error) {
+			if _, err := mapper.RESTMapping(gk); err != nil {
+				if meta.IsNoMatchError(err) {
+					return false, nil
+				}
+				return false, err
+			}
+			return true, nil
+		})
+		if err != nil {
+			return fmt.Errorf("kind %s is not discovered: %w", gk, err)
+		}
+	}
+
+	return nil
+}
+
+func managedBy(obj *unstructured.Unstructured, manager string) bool {
+	for _, f := range obj.GetManagedFields() {
+		if f.Manager == manager {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getCRDApplyPolicy(cr *appv1.HelmChart) appv1.CRDApplyPolicy {
+	if cr.Spec.CRDs == nil || cr.Spec.CRDs.Apply == "" {
+		return appv1.CRDCreateReplace
+	}
+
+	return cr.Spec.CRDs.Apply
+}
+
+func getCRDUninstallPolicy(cr *appv1.HelmChart) appv1.CRDUninstallPolicy {
+	if cr.Spec.CRDs == nil || cr.Spec.CRDs.Uninstall == "" {
+		return appv1.CRDKeep
+	}
+
+	return cr.Spec.CRDs.Uninstall
+}
+
+func crdEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if ok && cond["type"] == "Established" && cond["status"] == "True" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/diff"
+	"github.com/chenzhiwei/helm-operator/utils/yaml"
+)
+
+const missingField = "(missing)"
+
+// objectKey identifies an object in the manifests regardless of its version
+func objectKey(obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+	return gvk.Group + "/" + gvk.Kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// lastAppliedManifests returns the manifests of the current revision by object key
+func (r *HelmChartReconciler) lastAppliedManifests(ctx context.Context, cr *appv1.HelmChart) (map[string][]byte, error) {
+	result := map[string][]byte{}
+	if cr.Status.Revision == 0 {
+		return result, nil
+	}
+
+	rel, err := r.loadRevision(ctx, cr, cr.Status.Revision)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range rel.manifests {
+		obj, err := yaml.YamlToObject(m)
+		if err != nil {
+			return nil, err
+		}
+		result[objectKey(obj)] = m
+	}
+
+	return result, nil
+}
+
+// detectDrift compares the live object with the last applied manifest of it,
+// and returns the live object and the drifted fields, a deleted object is reported as missingField
+func (r *HelmChartReconciler) detectDrift(ctx context.Context, obj *unstructured.Unstructured, lastApplied []byte) (*unstructured.Unstructured, []string, error) {
+	last, err := yaml.YamlToObject(lastApplied)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(obj.GroupVersionKind())
+	if err := r.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, live); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, []string{missingField}, nil
+		}
+		return nil, nil, err
+	}
+
+	return live, diff.Fields(comparableFields(last), comparableFields(live)), nil
+}
+
+// comparableFields removes the fields which are not managed by the chart
+func comparableFields(obj *unstructured.Unstructured) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range obj.Object {
+		switch k {
+		case "apiVersion", "kind", "status", "stringData":
+			continue
+		case "metadata":
+			md, _ := v.(map[string]interface{})
+			metadata := map[string]interface{}{}
+			if labels, ok := md["labels"]; ok {
+				metadata["labels"] = labels
+			}
+			if annotations, ok := md["annotations"]; ok {
+				metadata["annotations"] = annotations
+			}
+			result[k] = metadata
+		default:
+			result[k] = v
+		}
+	}
+
+	return result
+}
+
+func getDriftPolicy(cr *appv1.HelmChart) appv1.DriftPolicy {
+	if cr.Spec.DriftPolicy == "" {
+		return appv1.DriftCorrect
+	}
+
+	return cr.Spec.DriftPolicy
+}
@@ -17,40 +17,112 @@ limitations under the License.
 package controllers
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
+	"helm.sh/helm/v3/pkg/chart"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	netv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
 	"github.com/chenzhiwei/helm-operator/utils"
 	"github.com/chenzhiwei/helm-operator/utils/constant"
+	"github.com/chenzhiwei/helm-operator/utils/health"
 	"github.com/chenzhiwei/helm-operator/utils/helm"
 	"github.com/chenzhiwei/helm-operator/utils/pointer"
-	"github.com/chenzhiwei/helm-operator/utils/yaml"
+)
+
+const (
+	defaultTimeout      = 5 * time.Minute
+	healthCheckInterval = 10 * time.Second
+	// the fetched chart is refreshed after spec.interval, or this if it is not set
+	chartRefreshInterval = 10 * time.Minute
+	intervalJitter       = 0.1
+
+	// fieldManager is the field manager of the HelmDogs, it was used for all resources in the early versions
+	fieldManager = "helmchart-controller"
+)
+
+// Event reasons of HelmChart and HelmDog
+const (
+	eventFetchFailed     = "FetchFailed"
+	eventRenderFailed    = "RenderFailed"
+	eventApplyFailed     = "ApplyFailed"
+	eventInstalled       = "Installed"
+	eventUpgraded        = "Upgraded"
+	eventTimeout         = "Timeout"
+	eventRollback        = "Rollback"
+	eventRollbackFailed  = "RollbackFailed"
+	eventRemediated      = "Remediated"
+	eventDriftDetected   = "DriftDetected"
+	eventDriftCorrected  = "DriftCorrected"
+	eventPlanned         = "Planned"
+	eventPlanFailed      = "PlanFailed"
+	eventApprovalPending = "ApprovalPending"
+	eventPruned          = "Pruned"
+	eventPruneFailed     = "PruneFailed"
+	eventCleanupFailed   = "CleanupFailed"
+	eventConflict        = "Conflict"
+	eventRecreated       = "Recreated"
+	eventOrphaned        = "Orphaned"
+	eventUninstalled     = "Uninstalled"
 )
 
 // HelmChartReconciler reconciles a HelmChart object
 type HelmChartReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// ApplyConcurrency is the max number of resources of the same kind applied at the same time
+	ApplyConcurrency int
+
+	controller controller.Controller
+	cache      cache.Cache
+	discovery  discovery.DiscoveryInterface
+
+	// watched is the kinds being watched
+	watchMu sync.Mutex
+	watched map[schema.GroupVersionKind]bool
+
+	// charts is the fetched charts by path
+	chartMu sync.Mutex
+	charts  map[string]cachedChart
+}
+
+type cachedChart struct {
+	chart     *chart.Chart
+	fetchedAt time.Time
 }
 
 //+kubebuilder:rbac:groups=app.siji.io,resources=helmcharts,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=app.siji.io,resources=helmcharts/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=app.siji.io,resources=helmcharts/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -75,22 +147,16 @@ func (r *HelmChartReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	// The CR is being deleted
 	if cr.DeletionTimestamp != nil {
 		log.V(3).Info("deleting the helmchart")
-		// delete resources in other namespaces or cluster scoped resources
-		if err := r.cleanResources(ctx, cr); err != nil {
-			log.Error(err, "failed to clean extra resources", "HelmDog", req.Name)
-			return ctrl.Result{}, err
-		}
-
-		// delete finalizer
-		if controllerutil.ContainsFinalizer(cr, constant.FinalizerName) {
-			controllerutil.RemoveFinalizer(cr, constant.FinalizerName)
-			if err := r.Update(ctx, cr); err != nil {
-				log.Error(err, "failed to remove finalizer")
+		if cr.Spec.DeletionPolicy == appv1.DeletionOrphan {
+			if err := r.orphanResources(ctx, cr); err != nil {
+				log.Error(err, "failed to orphan the resources")
+				r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventCleanupFailed, "failed to orphan the resources: %s", err)
 				return ctrl.Result{}, err
 			}
+			return r.removeFinalizer(ctx, cr)
 		}
 
-		return ctrl.Result{}, nil
+		return r.uninstall(ctx, cr)
 	}
 
 	// add finalizer
@@ -102,93 +168,175 @@ func (r *HelmChartReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		}
 	}
 
-	var err error
-	var manifests [][]byte
-
-	if os.Getenv("WEBHOOKS_ENABLED") == "true" {
-		secretName := utils.ManifestsSecretName(cr.Name, cr.Namespace)
-		log.V(1).Info("fetching Helm manifests from secret", "Secret", secretName+"/"+constant.HelmOperatorNamespace)
-		secret := &corev1.Secret{}
-		namespacedName := types.NamespacedName{
-			Name:      secretName,
-			Namespace: constant.HelmOperatorNamespace,
+	if cr.Spec.Suspend {
+		log.V(1).Info("the helmchart is suspended")
+		meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
+			Type:               appv1.SuspendedCondition,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: cr.Generation,
+			Reason:             appv1.ReasonSuspended,
+			Message:            "the reconciliation is suspended",
+		})
+		if err := r.Status().Update(ctx, cr); err != nil {
+			log.Error(err, "failed to update HelmChart status")
+			return ctrl.Result{}, err
 		}
-		if err := r.Get(ctx, namespacedName, secret); err != nil {
-			if errors.IsNotFound(err) {
-				return ctrl.Result{}, fmt.Errorf("webhook enabled, but no manifests secret found")
-			}
+		return ctrl.Result{}, nil
+	}
+	meta.RemoveStatusCondition(&cr.Status.Conditions, appv1.SuspendedCondition)
 
+	// do not render the chart again if nothing is changed
+	var rel *release
+	var ch *chart.Chart
+	var err error
+	if target, err := rollbackTarget(cr); err == nil && target == 0 && os.Getenv("WEBHOOKS_ENABLED") != "true" {
+		if ch, err = r.fetchChart(cr); err != nil {
+			r.setFailedStatus(ctx, cr, err)
 			return ctrl.Result{}, err
 		}
-		mBytes, ok := secret.Data["manifests"]
-		if ok {
-			sep := []byte("\n---\n")
-			manifests = bytes.Split(mBytes, sep)
-		} else {
-			return ctrl.Result{}, fmt.Errorf("webhook enabled, but manifests secret format is incorrect")
+	}
+	inputs := inputDigest(cr, ch)
+	unchanged := inputsUnchanged(cr, inputs)
+	if unchanged {
+		log.V(1).Info("the inputs are unchanged, checking drift only", "revision", cr.Status.Revision)
+		if rel, err = r.loadRevision(ctx, cr, cr.Status.Revision); err != nil {
+			log.Error(err, "failed to load the current revision, rendering the chart")
+			unchanged = false
 		}
-	} else {
-		log.V(1).Info("fetching Helm manifests from remote")
-		manifests, err = helm.GetManifests(cr.Name, cr.Namespace, cr.Spec.Chart.Path, cr.Spec.Values.Raw)
-		if err != nil {
-			log.Error(err, "failed to generate Helm manifests")
+	}
+	if !unchanged {
+		if rel, err = r.getRelease(ctx, cr, ch); err != nil {
+			r.setFailedStatus(ctx, cr, err)
 			return ctrl.Result{}, err
 		}
 	}
 
-	var resources []appv1.Resource
-
-	// var objects []*unstructured.Unstructured
-	for _, m := range manifests {
-		obj, _ := yaml.YamlToObject(m)
-
-		mapper, err := r.Client.RESTMapper().RESTMapping(obj.GroupVersionKind().GroupKind(), obj.GroupVersionKind().Version)
+	if cr.Spec.DryRun {
+		plan, err := r.plan(ctx, cr, rel)
 		if err != nil {
-			log.Error(err, "failed to get RESTMapper")
+			log.Error(err, "failed to plan the changes")
+			r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventPlanFailed, "failed to plan the changes: %s", err)
+			r.setFailedStatus(ctx, cr, err)
 			return ctrl.Result{}, err
 		}
 
-		// set namespace to obj because Helm does not add it
-		ns := obj.GetNamespace()
-		if ns == "" && mapper.Scope.Name() == "namespace" {
-			obj.SetNamespace(cr.Namespace)
+		last := cr.Status.Plan
+		if last != nil && last.Digest == plan.Digest && last.Added == plan.Added && last.Changed == plan.Changed && last.Removed == plan.Removed {
+			plan.PlannedAt = last.PlannedAt
+		} else {
+			r.Recorder.Eventf(cr, corev1.EventTypeNormal, eventPlanned, "%d to add, %d to change, %d to remove, see ConfigMap %s",
+				plan.Added, plan.Changed, plan.Removed, plan.ConfigMap)
+		}
+		cr.Status.Plan = plan
+		if err := r.Status().Update(ctx, cr); err != nil {
+			log.Error(err, "failed to update HelmChart status")
+			return ctrl.Result{}, err
 		}
+		return withInterval(cr, ctrl.Result{}), nil
+	}
 
-		if obj.GetNamespace() == cr.Namespace {
-			if err := controllerutil.SetControllerReference(cr, obj, r.Scheme); err != nil {
-				log.Error(err, "failed to set owner reference")
+	if rel.revision == 0 {
+		if rel, err = r.approve(ctx, cr, rel); err != nil {
+			log.Error(err, "failed to check the approval")
+			r.setFailedStatus(ctx, cr, err)
+			return ctrl.Result{}, err
+		}
+		// the first revision is waiting for approval
+		if rel == nil {
+			if err := r.Status().Update(ctx, cr); err != nil {
+				log.Error(err, "failed to update HelmChart status")
 				return ctrl.Result{}, err
 			}
-		} else {
-			// store the cluster scoped resource for cleanResources
-			resource := appv1.Resource{
-				Group:     obj.GroupVersionKind().Group,
-				Version:   obj.GroupVersionKind().Version,
-				Kind:      obj.GroupVersionKind().Kind,
-				Name:      obj.GetName(),
-				Namespace: obj.GetNamespace(),
-			}
-			resources = append(resources, resource)
+			return withInterval(cr, ctrl.Result{}), nil
 		}
+	}
 
-		log.Info("creating Helm manifest", "kind", obj.GetKind(), "name", obj.GetName(), "namespace", obj.GetNamespace())
-		// TODO: better server side apply
-		patchOptions := &client.PatchOptions{
-			FieldManager: "helmchart-controller",
-			Force:        pointer.Bool(true),
-		}
-		if err := r.Patch(ctx, obj, client.Apply, patchOptions); err != nil {
-			log.Error(err, "failed running server side apply")
+	if rel.revision == 0 {
+		if rel, err = r.remediate(ctx, cr, rel); err != nil {
+			log.Error(err, "failed to remediate the failed upgrade")
+			r.setFailedStatus(ctx, cr, err)
 			return ctrl.Result{}, err
 		}
+		// the remediation retries are exhausted, do not retry until the manifests are changed
+		if rel == nil {
+			r.setFailedStatus(ctx, cr, fmt.Errorf("failed to apply the manifests %d times", cr.Status.Failures))
+			return ctrl.Result{}, nil
+		}
+	}
+
+	// without the current revision there is no baseline of drift, all the manifests are applied
+	// and recorded as a new revision
+	lastApplied, err := r.lastAppliedManifests(ctx, cr)
+	if err != nil {
+		log.Error(err, "failed to get the last applied manifests, applying all of them")
+		lastApplied = map[string][]byte{}
+		rel.force = true
+	}
+
+	crds, manifests, err := splitCRDs(rel.manifests)
+	if err != nil {
+		log.Error(err, "failed to parse the manifests")
+		r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventApplyFailed, "failed to parse the manifests: %s", err)
+		r.recordApplyFailure(ctx, cr, rel, err)
+		return ctrl.Result{}, err
+	}
+
+	st := &applyState{lastApplied: lastApplied, driftOnly: unchanged}
+
+	// the CRDs must be established before the custom resources are applied
+	if crds, err = r.applyCRDs(ctx, cr, st, crds); err != nil {
+		log.Error(err, "failed running server side apply on CRDs")
+		r.recordApplyFailure(ctx, cr, rel, err)
+		return ctrl.Result{}, err
+	}
+	if err := r.waitForCRDs(ctx, crds); err != nil {
+		log.Error(err, "failed to wait for the CRDs")
+		r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventApplyFailed, "failed to wait for the CRDs: %s", err)
+		r.recordApplyFailure(ctx, cr, rel, err)
+		return ctrl.Result{}, err
+	}
+
+	waves, err := splitWaves(manifests)
+	if err != nil {
+		log.Error(err, "failed to parse the manifests")
+		r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventApplyFailed, "failed to split the waves: %s", err)
+		r.recordApplyFailure(ctx, cr, rel, err)
+		return ctrl.Result{}, err
+	}
+	pending, err := r.applyWaves(ctx, cr, st, waves)
+	if err != nil {
+		log.Error(err, "failed running server side apply")
+		r.recordApplyFailure(ctx, cr, rel, err)
+		return ctrl.Result{}, err
 	}
+	if err := r.trackManifests(cr, st, pending); err != nil {
+		log.Error(err, "failed to track the resources of the pending waves")
+		r.recordApplyFailure(ctx, cr, rel, err)
+		return ctrl.Result{}, err
+	}
+	resources, objects, drift := st.resources, st.objects, st.drift
+	r.watchKinds(objects)
+	setConflictStatus(cr, st.conflicts)
 
 	if len(resources) > 0 {
+		helmDogSpec := &appv1.HelmDogSpec{
+			Resources: resources,
+			CRDPolicy: getCRDUninstallPolicy(cr),
+		}
+		if cr.Spec.Uninstall != nil {
+			helmDogSpec.GracePeriod = cr.Spec.Uninstall.GracePeriod
+			helmDogSpec.FinalizerPolicies = cr.Spec.Uninstall.FinalizerPolicies
+		}
+		spec, err := runtime.DefaultUnstructuredConverter.ToUnstructured(helmDogSpec)
+		if err != nil {
+			log.Error(err, "failed to convert HelmDog spec")
+			r.recordApplyFailure(ctx, cr, rel, err)
+			return ctrl.Result{}, err
+		}
+
 		helmDog := &unstructured.Unstructured{}
 		helmDog.Object = map[string]interface{}{
-			"spec": map[string]interface{}{
-				"resources": resources,
-			},
+			"spec": spec,
 		}
 
 		helmDog.SetGroupVersionKind(schema.GroupVersionKind{
@@ -204,11 +352,57 @@ func (r *HelmChartReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		log.Info("creating HelmDog for extra resources")
 		// TODO: better server side apply
 		patchOptions := &client.PatchOptions{
-			FieldManager: "helmchart-controller",
+			FieldManager: fieldManager,
 			Force:        pointer.Bool(true),
 		}
 		if err := r.Patch(ctx, helmDog, client.Apply, patchOptions); err != nil {
 			log.Error(err, "failed running server side apply on helmdog")
+			r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventApplyFailed, "failed to apply HelmDog: %s", err)
+			r.recordApplyFailure(ctx, cr, rel, err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// the revision is recorded after all the waves are applied
+	if len(pending) > 0 {
+		cr.Status.Drift = drift
+		return r.updateReadyStatus(ctx, cr, objects)
+	}
+
+	if rel.revision == 0 {
+		cr.Status.Failures = 0
+	}
+	cr.Status.Drift = drift
+
+	previous := cr.Status.Revision
+	if err := r.recordRevision(ctx, cr, rel); err != nil {
+		log.Error(err, "failed to record the revision")
+		r.setFailedStatus(ctx, cr, err)
+		return ctrl.Result{}, err
+	}
+	// the events are emitted only when a new revision is recorded, a rollback has its own event
+	if previous == 0 && cr.Status.Revision > 0 {
+		r.Recorder.Eventf(cr, corev1.EventTypeNormal, eventInstalled, "applied %d resources", len(objects))
+	} else if rel.revision == 0 && cr.Status.Revision != previous {
+		r.Recorder.Eventf(cr, corev1.EventTypeNormal, eventUpgraded, "applied %d resources", len(objects))
+	}
+	if rel.revision == 0 {
+		cr.Status.InputDigest = inputs
+	} else if !unchanged {
+		// rolled back or waiting for approval, the current revision is not rendered from the inputs
+		cr.Status.InputDigest = ""
+	}
+
+	return r.updateReadyStatus(ctx, cr, objects)
+}
+
+func (r *HelmChartReconciler) removeFinalizer(ctx context.Context, cr *appv1.HelmChart) (ctrl.Result, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	if controllerutil.ContainsFinalizer(cr, constant.FinalizerName) {
+		controllerutil.RemoveFinalizer(cr, constant.FinalizerName)
+		if err := r.Update(ctx, cr); err != nil {
+			log.Error(err, "failed to remove finalizer")
 			return ctrl.Result{}, err
 		}
 	}
@@ -216,6 +410,282 @@ func (r *HelmChartReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	return ctrl.Result{}, nil
 }
 
+// prepareObject sets the namespace and owner reference of an object from the manifests
+func (r *HelmChartReconciler) prepareObject(cr *appv1.HelmChart, obj *unstructured.Unstructured) error {
+	mapper, err := r.Client.RESTMapper().RESTMapping(obj.GroupVersionKind().GroupKind(), obj.GroupVersionKind().Version)
+	if err != nil {
+		return err
+	}
+
+	// set namespace to obj because Helm does not add it
+	if obj.GetNamespace() == "" && mapper.Scope.Name() == meta.RESTScopeNameNamespace {
+		obj.SetNamespace(cr.Namespace)
+	}
+
+	// a shared resource is referenced by multiple HelmCharts and tracked by their HelmDogs
+	if isShared(obj) {
+		setReference(cr.Namespace, cr.Name, obj)
+		return nil
+	}
+
+	if obj.GetNamespace() == cr.Namespace {
+		return controllerutil.SetControllerReference(cr, obj, r.Scheme)
+	}
+
+	// the owner reference can not be used across namespaces, track the resource by labels
+	setTrackingLabels(cr, obj)
+
+	return nil
+}
+
+func resourceOf(obj *unstructured.Unstructured) appv1.Resource {
+	return appv1.Resource{
+		Group:     obj.GroupVersionKind().Group,
+		Version:   obj.GroupVersionKind().Version,
+		Kind:      obj.GroupVersionKind().Kind,
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+	}
+}
+
+// trackedResource returns the resource tracked by HelmDog with the preferred version of the kind,
+// so the resource is not pruned when a chart moves it to another API version
+func (r *HelmChartReconciler) trackedResource(obj *unstructured.Unstructured) appv1.Resource {
+	res := resourceOf(obj)
+	mapping, err := r.RESTMapper().RESTMapping(obj.GroupVersionKind().GroupKind())
+	if err == nil {
+		res.Version = mapping.GroupVersionKind.Version
+	}
+
+	return res
+}
+
+// updateReadyStatus sets the Ready condition according to the health of the applied objects,
+// and requeues the HelmChart until all of them are healthy or the timeout is reached
+func (r *HelmChartReconciler) updateReadyStatus(ctx context.Context, cr *appv1.HelmChart, objects []*unstructured.Unstructured) (ctrl.Result, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	healthy, msg, err := checkHealth(objects)
+	if err != nil {
+		log.Error(err, "failed to check resources health")
+		return ctrl.Result{}, err
+	}
+
+	// a new generation or recovering from failure restarts the timeout
+	current := meta.FindStatusCondition(cr.Status.Conditions, appv1.ReadyCondition)
+	if current != nil && (cr.Status.ObservedGeneration != cr.Generation || current.Reason == appv1.ReasonFailed) {
+		meta.RemoveStatusCondition(&cr.Status.Conditions, appv1.ReadyCondition)
+		current = nil
+	}
+
+	var result ctrl.Result
+	condition := metav1.Condition{
+		Type:               appv1.ReadyCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: cr.Generation,
+		Reason:             appv1.ReasonHealthy,
+		Message:            "all resources are ready",
+	}
+
+	if !healthy {
+		start := time.Now()
+		if current != nil && current.Status == metav1.ConditionFalse {
+			start = current.LastTransitionTime.Time
+		}
+
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = appv1.ReasonProgressing
+		condition.Message = msg
+		if time.Since(start) > getTimeout(cr) {
+			condition.Reason = appv1.ReasonTimeout
+			condition.Message = "timed out waiting for " + msg
+			r.Recorder.Event(cr, corev1.EventTypeWarning, eventTimeout, condition.Message)
+		} else {
+			log.V(1).Info("waiting for resources to become ready", "reason", msg)
+			result.RequeueAfter = healthCheckInterval
+		}
+	}
+
+	meta.SetStatusCondition(&cr.Status.Conditions, condition)
+	cr.Status.ObservedGeneration = cr.Generation
+	if err := r.Status().Update(ctx, cr); err != nil {
+		log.Error(err, "failed to update HelmChart status")
+		return ctrl.Result{}, err
+	}
+
+	return withInterval(cr, result), nil
+}
+
+// setFailedStatus records the reconcile error in the Ready condition
+func (r *HelmChartReconciler) setFailedStatus(ctx context.Context, cr *appv1.HelmChart, reconcileErr error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
+		Type:               appv1.ReadyCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: cr.Generation,
+		Reason:             appv1.ReasonFailed,
+		Message:            reconcileErr.Error(),
+	})
+	cr.Status.ObservedGeneration = cr.Generation
+	if err := r.Status().Update(ctx, cr); err != nil {
+		log.Error(err, "failed to update HelmChart status")
+	}
+}
+
+func checkHealth(objects []*unstructured.Unstructured) (bool, string, error) {
+	for _, obj := range objects {
+		healthy, msg, err := health.Check(obj)
+		if err != nil {
+			return false, "", err
+		}
+		if !healthy {
+			return false, fmt.Sprintf("%s %s: %s", obj.GetKind(), obj.GetName(), msg), nil
+		}
+	}
+
+	return true, "", nil
+}
+
+// withInterval requeues the HelmChart after the jittered interval unless it is requeued earlier
+func withInterval(cr *appv1.HelmChart, result ctrl.Result) ctrl.Result {
+	if cr.Spec.Interval == nil || cr.Spec.Interval.Duration <= 0 {
+		return result
+	}
+
+	interval := wait.Jitter(cr.Spec.Interval.Duration, intervalJitter)
+	if result.RequeueAfter == 0 || result.RequeueAfter > interval {
+		result.RequeueAfter = interval
+	}
+
+	return result
+}
+
+func getTimeout(cr *appv1.HelmChart) time.Duration {
+	if cr.Spec.Timeout != nil {
+		return cr.Spec.Timeout.Duration
+	}
+
+	return defaultTimeout
+}
+
+// fetchChart locates and loads the chart of a HelmChart, the fetched chart is reused until the
+// refresh interval is due or the spec of the HelmChart is changed
+func (r *HelmChartReconciler) fetchChart(cr *appv1.HelmChart) (*chart.Chart, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	refresh := chartRefreshInterval
+	if cr.Spec.Interval != nil && cr.Spec.Interval.Duration > 0 {
+		refresh = cr.Spec.Interval.Duration
+	}
+
+	path := cr.Spec.Chart.Path
+	r.chartMu.Lock()
+	cached, ok := r.charts[path]
+	r.chartMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < refresh && cr.Status.ObservedGeneration == cr.Generation {
+		return cached.chart, nil
+	}
+
+	log.V(1).Info("fetching Helm chart from remote")
+	ch, err := helm.GetChart(path)
+	if err != nil {
+		log.Error(err, "failed to fetch Helm chart")
+		r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventFetchFailed, "failed to fetch chart %s: %s", path, err)
+		return nil, err
+	}
+
+	r.chartMu.Lock()
+	defer r.chartMu.Unlock()
+	if r.charts == nil {
+		r.charts = map[string]cachedChart{}
+	}
+	// drop the charts not used for a long time
+	for p, c := range r.charts {
+		if time.Since(c.fetchedAt) > 2*refresh {
+			delete(r.charts, p)
+		}
+	}
+	r.charts[path] = cachedChart{chart: ch, fetchedAt: time.Now()}
+
+	return ch, nil
+}
+
+// getRelease renders the manifests from the fetched chart, the stored revision to roll back to,
+// or the manifests secret of the webhook
+func (r *HelmChartReconciler) getRelease(ctx context.Context, cr *appv1.HelmChart, ch *chart.Chart) (*release, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	target, err := rollbackTarget(cr)
+	if err != nil {
+		r.Recorder.Event(cr, corev1.EventTypeWarning, eventRollbackFailed, err.Error())
+		return nil, err
+	}
+
+	if target > 0 {
+		log.V(1).Info("fetching Helm manifests from revision", "revision", target)
+		rel, err := r.loadRevision(ctx, cr, target)
+		if err != nil {
+			log.Error(err, "failed to load revision", "revision", target)
+			r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventRollbackFailed, "failed to load revision %d: %s", target, err)
+			return nil, err
+		}
+		if cr.Status.Revision != target {
+			r.Recorder.Eventf(cr, corev1.EventTypeNormal, eventRollback, "rolling back to revision %d", target)
+		}
+
+		return rel, nil
+	}
+
+	rel := &release{
+		valuesHash: digest(cr.Spec.Values.Raw),
+	}
+
+	if os.Getenv("WEBHOOKS_ENABLED") == "true" {
+		secretName := utils.ManifestsSecretName(cr.Name, cr.Namespace)
+		log.V(1).Info("fetching Helm manifests from secret", "Secret", secretName+"/"+constant.HelmOperatorNamespace)
+		secret := &corev1.Secret{}
+		namespacedName := types.NamespacedName{
+			Name:      secretName,
+			Namespace: constant.HelmOperatorNamespace,
+		}
+		if err := r.Get(ctx, namespacedName, secret); err != nil {
+			if errors.IsNotFound(err) {
+				err = fmt.Errorf("webhook enabled, but no manifests secret found")
+			}
+
+			r.Recorder.Event(cr, corev1.EventTypeWarning, eventFetchFailed, err.Error())
+			return nil, err
+		}
+		mBytes, ok := secret.Data["manifests"]
+		if !ok {
+			err := fmt.Errorf("webhook enabled, but manifests secret format is incorrect")
+			r.Recorder.Event(cr, corev1.EventTypeWarning, eventFetchFailed, err.Error())
+			return nil, err
+		}
+		rel.manifests = splitManifests(mBytes)
+		rel.chartVersion = string(secret.Data["chartVersion"])
+
+		return rel, nil
+	}
+
+	if ch == nil {
+		if ch, err = r.fetchChart(cr); err != nil {
+			return nil, err
+		}
+	}
+
+	rel.manifests, err = helm.RenderManifests(cr.Name, cr.Namespace, ch, cr.Spec.Values.Raw)
+	if err != nil {
+		log.Error(err, "failed to generate Helm manifests")
+		r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventRenderFailed, "failed to render chart: %s", err)
+		return nil, err
+	}
+	rel.chartVersion = ch.Metadata.Version
+
+	return rel, nil
+}
+
 func (r *HelmChartReconciler) cleanResources(ctx context.Context, cr *appv1.HelmChart) error {
 	helmDog := &appv1.HelmDog{}
 	helmDog.SetName(cr.Name)
@@ -225,18 +695,49 @@ func (r *HelmChartReconciler) cleanResources(ctx context.Context, cr *appv1.Helm
 	return client.IgnoreNotFound(err)
 }
 
+// deletingPredicate passes the updates of the objects being deleted
+var deletingPredicate = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return e.ObjectNew.GetDeletionTimestamp() != nil
+	},
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *HelmChartReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	// Only watch these widely used resources
-	// The reconcile period is 5 hours which is for other resources
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&appv1.HelmChart{}).
-		Owns(&corev1.Service{}).
-		Owns(&corev1.Secret{}).
-		Owns(&corev1.ConfigMap{}).
-		Owns(&appsv1.DaemonSet{}).
-		Owns(&appsv1.Deployment{}).
-		Owns(&appsv1.StatefulSet{}).
-		Owns(&netv1.Ingress{}).
-		Complete(r)
+	dc, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	r.discovery = dc
+
+	// These widely used resources are watched with typed informers,
+	// the other kinds are watched when they are applied
+	owned := []client.Object{
+		&corev1.Service{},
+		&corev1.Secret{},
+		&corev1.ConfigMap{},
+		&appsv1.DaemonSet{},
+		&appsv1.Deployment{},
+		&appsv1.StatefulSet{},
+		&netv1.Ingress{},
+	}
+	if err := r.markWatched(owned...); err != nil {
+		return err
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&appv1.HelmChart{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}, deletingPredicate)))
+	for _, obj := range owned {
+		b = b.Owns(obj).
+			Watches(&source.Kind{Type: obj}, handler.EnqueueRequestsFromMapFunc(trackedHelmChart))
+	}
+
+	c, err := b.Build(r)
+	if err != nil {
+		return err
+	}
+	r.controller = c
+	r.cache = mgr.GetCache()
+
+	return nil
 }
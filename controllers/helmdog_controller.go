@@ -19,24 +19,37 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"reflect"
 	"strings"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/constant"
+)
+
+const (
+	defaultGracePeriod     = 5 * time.Minute
+	finalizerCheckInterval = 10 * time.Second
 )
 
 // HelmDogReconciler reconciles a HelmDog object
 type HelmDogReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=app.siji.io,resources=helmdogs,verbs=get;list;watch;create;update;patch;delete
@@ -67,9 +80,22 @@ func (r *HelmDogReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	// The CR is being deleted
 	if cr.DeletionTimestamp != nil {
 		log.V(3).Info("deleting the helmdog")
-		if err := r.deleteResources(ctx, cr.Spec.Resources); err != nil {
+		states, err := r.deleteResources(ctx, cr, cr.Spec.Resources)
+		if err != nil {
 			log.Error(err, "failed to delete extra resources")
-			return ctrl.Result{}, err
+			r.Recorder.Event(cr, corev1.EventTypeWarning, eventCleanupFailed, err.Error())
+		}
+		if err != nil || countPhase(states, appv1.ResourceDeleting) > 0 {
+			setHelmDogStatus(cr, states)
+			if err := r.Status().Update(ctx, cr); err != nil {
+				log.Error(err, "failed to update HelmDog status")
+				return ctrl.Result{}, client.IgnoreNotFound(err)
+			}
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			log.V(1).Info("waiting for the finalizers of the deleted resources", "count", countPhase(states, appv1.ResourceDeleting))
+			return ctrl.Result{RequeueAfter: finalizerCheckInterval}, nil
 		}
 
 		cr.SetFinalizers(nil)
@@ -89,46 +115,98 @@ func (r *HelmDogReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, nil
 	}
 
+	states := r.checkResources(ctx, cr.Spec.Resources)
+
 	// Remove the unused resources
 	resources := getDeletedResources(cr.Spec.Resources, cr.Status.Resources)
+	var pruned []appv1.ResourceState
+	var pruneErr error
 	if len(resources) > 0 {
 		log.V(3).Info("remove the unused resources in cr.Status.Resources")
-		if err := r.deleteResources(ctx, resources); err != nil {
-			log.Error(err, "failed to remove unused resources in cr.Status.Resources")
-			return ctrl.Result{}, err
+		pruned, pruneErr = r.deleteResources(ctx, cr, resources)
+		if pruneErr != nil {
+			log.Error(pruneErr, "failed to remove unused resources in cr.Status.Resources")
+			r.Recorder.Event(cr, corev1.EventTypeWarning, eventPruneFailed, pruneErr.Error())
+		}
+		if removed := countPhase(pruned, appv1.ResourceDeleted); removed > 0 {
+			r.Recorder.Eventf(cr, corev1.EventTypeNormal, eventPruned, "removed %d unused resources", removed)
 		}
 	}
 
-	// Update status to use new resources
+	// Update status to use new resources, the deleting and failed resources are kept to be checked again
 	cr.Status.Resources = cr.Spec.Resources
+	for _, state := range pruned {
+		if state.Phase == appv1.ResourceDeleting || state.Phase == appv1.ResourceFailed {
+			cr.Status.Resources = append(cr.Status.Resources, state.Resource)
+		}
+	}
+	setHelmDogStatus(cr, append(states, pruned...))
 	if err := r.Status().Update(ctx, cr); err != nil {
 		log.Error(err, "failed to update cr.Status.Resources with new resources")
 		return ctrl.Result{}, err
 	}
 
+	if pruneErr != nil {
+		return ctrl.Result{}, pruneErr
+	}
+	if countPhase(pruned, appv1.ResourceDeleting) > 0 {
+		return ctrl.Result{RequeueAfter: finalizerCheckInterval}, nil
+	}
+
 	return ctrl.Result{}, nil
 }
 
-func (r *HelmDogReconciler) deleteResources(ctx context.Context, resources []appv1.Resource) error {
+// checkResources returns the states of the tracked resources
+func (r *HelmDogReconciler) checkResources(ctx context.Context, resources []appv1.Resource) []appv1.ResourceState {
+	var states []appv1.ResourceState
+	for _, res := range resources {
+		state := appv1.ResourceState{Resource: res, Phase: appv1.ResourcePresent}
+
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(schema.GroupVersionKind{Group: res.Group, Version: res.Version, Kind: res.Kind})
+		if err := r.Get(ctx, types.NamespacedName{Name: res.Name, Namespace: res.Namespace}, obj); err != nil {
+			state.Phase = appv1.ResourceFailed
+			state.Message = err.Error()
+			if errors.IsNotFound(err) {
+				state.Phase = appv1.ResourceDeleted
+				state.Message = ""
+			}
+		} else if obj.GetDeletionTimestamp() != nil {
+			state.Phase = appv1.ResourceDeleting
+			state.Finalizers = obj.GetFinalizers()
+			state.DeletedAt = obj.GetDeletionTimestamp()
+		}
+
+		states = append(states, state)
+	}
+
+	return states
+}
+
+// deleteResources deletes the resources in reverse order, and returns the states of them
+func (r *HelmDogReconciler) deleteResources(ctx context.Context, cr *appv1.HelmDog, resources []appv1.Resource) ([]appv1.ResourceState, error) {
 	log := ctrl.Log.WithName("controller.helmdog")
 
-	var errMsg []string
+	var states []appv1.ResourceState
+	var errs []error
 	for i := len(resources) - 1; i >= 0; i-- {
 		res := resources[i]
 		log.Info("delete Resource", "group", res.Group, "version", res.Version, "kind", res.Kind, "name", res.Name, "namespace", res.Namespace)
-		if err := r.deleteResource(ctx, res); err != nil {
-			errMsg = append(errMsg, fmt.Sprintf("Failed to delete: %s.%s/%s, name: %s, namespace: %s, msg: %s", res.Kind, res.Group, res.Version, res.Name, res.Namespace, err.Error()))
+		state, err := r.deleteResource(ctx, cr, res)
+		if err != nil {
+			state.Phase = appv1.ResourceFailed
+			state.Message = err.Error()
+			errs = append(errs, fmt.Errorf("failed to delete %s.%s/%s, name: %s, namespace: %s: %w", res.Kind, res.Group, res.Version, res.Name, res.Namespace, err))
 		}
+		states = append(states, state)
 	}
 
-	if len(errMsg) > 0 {
-		return fmt.Errorf(strings.Join(errMsg, ","))
-	}
-
-	return nil
+	return states, utilerrors.NewAggregate(errs)
 }
 
-func (r *HelmDogReconciler) deleteResource(ctx context.Context, res appv1.Resource) error {
+func (r *HelmDogReconciler) deleteResource(ctx context.Context, cr *appv1.HelmDog, res appv1.Resource) (appv1.ResourceState, error) {
+	state := appv1.ResourceState{Resource: res, Phase: appv1.ResourceDeleted}
+
 	obj := &unstructured.Unstructured{}
 	obj.SetGroupVersionKind(schema.GroupVersionKind{
 		Group:   res.Group,
@@ -137,39 +215,167 @@ func (r *HelmDogReconciler) deleteResource(ctx context.Context, res appv1.Resour
 	})
 
 	if err := r.Get(ctx, types.NamespacedName{Name: res.Name, Namespace: res.Namespace}, obj); err != nil {
-		return client.IgnoreNotFound(err)
+		return state, client.IgnoreNotFound(err)
+	}
+
+	// Do not delete the object recreated by others after it was applied
+	if res.UID != "" && string(obj.GetUID()) != res.UID {
+		state.Phase = appv1.ResourceKept
+		state.Message = fmt.Sprintf("UID %s does not match the applied UID %s", obj.GetUID(), res.UID)
+		return state, nil
+	}
+
+	// Do not delete the resource tracked by another HelmChart
+	labels := obj.GetLabels()
+	name, namespace := labels[constant.HelmChartNameLabel], labels[constant.HelmChartNamespaceLabel]
+	if name != "" && (name != cr.Name || namespace != cr.Namespace) {
+		state.Phase = appv1.ResourceKept
+		state.Message = "tracked by HelmChart " + namespace + "/" + name
+		return state, nil
 	}
 
 	// Do not delete the resource if it has annotation app.siji.io/keep
 	// This is mainly for multiple charts share same resource
-	if _, ok := obj.GetAnnotations()["app.siji.io/keep"]; ok {
-		return nil
+	if _, ok := obj.GetAnnotations()[constant.KeepAnnotation]; ok {
+		state.Phase = appv1.ResourceKept
+		state.Message = "annotation " + constant.KeepAnnotation + " is set"
+		return state, nil
+	}
+
+	// Remove the reference of the HelmChart from a shared resource, and keep it until the last reference is gone.
+	// The patch and delete are locked by the resource version, so the references changed by others at the
+	// same time are not lost
+	var deleteOpts []client.DeleteOption
+	if refs := references(obj); len(refs) > 0 {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			patch := client.MergeFromWithOptions(obj.DeepCopy(), client.MergeFromWithOptimisticLock{})
+			if !removeReference(cr.Namespace, cr.Name, obj) {
+				return nil
+			}
+			err := r.Patch(ctx, obj, patch)
+			if errors.IsConflict(err) {
+				if err := r.Get(ctx, types.NamespacedName{Name: res.Name, Namespace: res.Namespace}, obj); err != nil {
+					return err
+				}
+			}
+			return err
+		})
+		if err != nil {
+			return state, client.IgnoreNotFound(err)
+		}
+		rv := obj.GetResourceVersion()
+		deleteOpts = append(deleteOpts, client.Preconditions{ResourceVersion: &rv})
+
+		var others []string
+		for _, ref := range references(obj) {
+			others = append(others, ref.String())
+		}
+		if len(others) > 0 {
+			state.Phase = appv1.ResourceKept
+			state.Message = "referenced by " + strings.Join(others, ", ")
+			return state, nil
+		}
 	}
 
-	// Do not delete the CRD
-	if res.Kind == "CustomResourceDefinition" {
-		return nil
+	// Do not delete the CRD unless it has annotation app.siji.io/force-crd-delete, or the policy is Delete
+	// when uninstalling, a CRD removed from the chart is kept with the custom resources of it
+	if res.Kind == "CustomResourceDefinition" && (cr.Spec.CRDPolicy != appv1.CRDDelete || cr.DeletionTimestamp == nil) {
+		if _, ok := obj.GetAnnotations()[constant.ForceCRDDeleteAnnotation]; !ok {
+			state.Phase = appv1.ResourceKept
+			state.Message = "CRDs are kept"
+			return state, nil
+		}
 	}
 
 	// Delete the resource
-	if err := r.Delete(ctx, obj); err != nil {
-		return client.IgnoreNotFound(err)
+	if obj.GetDeletionTimestamp() == nil {
+		if err := r.Delete(ctx, obj, deleteOpts...); err != nil {
+			return state, client.IgnoreNotFound(err)
+		}
+
+		// Trying to get the resource
+		if err := r.Get(ctx, types.NamespacedName{Name: res.Name, Namespace: res.Namespace}, obj); err != nil {
+			return state, client.IgnoreNotFound(err)
+		}
 	}
 
-	// Trying to get the resource
-	if err := r.Get(ctx, types.NamespacedName{Name: res.Name, Namespace: res.Namespace}, obj); err != nil {
-		return client.IgnoreNotFound(err)
+	deletedAt := metav1.Now()
+	if obj.GetDeletionTimestamp() != nil {
+		deletedAt = *obj.GetDeletionTimestamp()
 	}
 
-	// Force delete the resource by removing its finalizers
-	if len(obj.GetFinalizers()) > 0 {
+	// Force delete the resource by removing its finalizers after the grace period
+	action, gracePeriod := finalizerPolicy(cr, res)
+	if len(obj.GetFinalizers()) > 0 && action == appv1.FinalizerRemove && time.Since(deletedAt.Time) >= gracePeriod {
 		obj.SetFinalizers(nil)
 		if err := r.Update(ctx, obj); err != nil {
-			return client.IgnoreNotFound(err)
+			return state, client.IgnoreNotFound(err)
+		}
+		return state, nil
+	}
+
+	state.Phase = appv1.ResourceDeleting
+	state.Finalizers = obj.GetFinalizers()
+	state.DeletedAt = &deletedAt
+	if len(state.Finalizers) > 0 {
+		state.Message = fmt.Sprintf("waiting for finalizers, action %s", action)
+	}
+
+	return state, nil
+}
+
+func countPhase(states []appv1.ResourceState, phase appv1.ResourcePhase) int {
+	count := 0
+	for _, state := range states {
+		if state.Phase == phase {
+			count++
 		}
 	}
 
-	return nil
+	return count
+}
+
+// setHelmDogStatus sets the resource states and the Ready condition summarizing them
+func setHelmDogStatus(cr *appv1.HelmDog, states []appv1.ResourceState) {
+	cr.Status.ResourceStates = states
+
+	condition := metav1.Condition{
+		Type:               appv1.ReadyCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: cr.Generation,
+		Reason:             appv1.ReasonSynced,
+		Message:            fmt.Sprintf("%d resources are tracked", len(cr.Spec.Resources)),
+	}
+	if failed := countPhase(states, appv1.ResourceFailed); failed > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = appv1.ReasonFailed
+		condition.Message = fmt.Sprintf("%d resources failed, see status.resourceStates", failed)
+	} else if deleting := countPhase(states, appv1.ResourceDeleting); deleting > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = appv1.ReasonDeleting
+		condition.Message = fmt.Sprintf("%d resources are waiting for finalizers", deleting)
+	}
+	meta.SetStatusCondition(&cr.Status.Conditions, condition)
+}
+
+// finalizerPolicy returns the action and grace period on the finalizers of a resource
+func finalizerPolicy(cr *appv1.HelmDog, res appv1.Resource) (appv1.FinalizerAction, time.Duration) {
+	gracePeriod := defaultGracePeriod
+	if cr.Spec.GracePeriod != nil {
+		gracePeriod = cr.Spec.GracePeriod.Duration
+	}
+
+	for _, p := range cr.Spec.FinalizerPolicies {
+		if p.Group != res.Group || p.Kind != res.Kind {
+			continue
+		}
+		if p.GracePeriod != nil {
+			gracePeriod = p.GracePeriod.Duration
+		}
+		return p.Action, gracePeriod
+	}
+
+	return appv1.FinalizerRemove, gracePeriod
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -179,6 +385,10 @@ func (r *HelmDogReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Complete(r)
 }
 
+func sameResource(a, b appv1.Resource) bool {
+	return a.Group == b.Group && a.Kind == b.Kind && a.Namespace == b.Namespace && a.Name == b.Name
+}
+
 func getDeletedResources(resources, currentResources []appv1.Resource) []appv1.Resource {
 	var result []appv1.Resource
 	for _, resource := range currentResources {
@@ -190,9 +400,11 @@ func getDeletedResources(resources, currentResources []appv1.Resource) []appv1.R
 	return result
 }
 
+// containResource compares the resources by group, kind, namespace and name, an object of another
+// API version or a recreated object is the same resource
 func containResource(resources []appv1.Resource, resource appv1.Resource) bool {
 	for _, res := range resources {
-		if reflect.DeepEqual(res, resource) {
+		if sameResource(res, resource) {
 			return true
 		}
 	}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+)
+
+func TestGetDeletedResources(t *testing.T) {
+	pdb := appv1.Resource{Group: "policy", Version: "v1beta1", Kind: "PodDisruptionBudget", Name: "web", Namespace: "default"}
+	role := appv1.Resource{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole", Name: "web", UID: "uid-1"}
+
+	withVersion := func(res appv1.Resource, version string) appv1.Resource {
+		res.Version = version
+		return res
+	}
+	withUID := func(res appv1.Resource, uid string) appv1.Resource {
+		res.UID = uid
+		return res
+	}
+	withName := func(res appv1.Resource, name string) appv1.Resource {
+		res.Name = name
+		return res
+	}
+
+	tests := []struct {
+		name      string
+		resources []appv1.Resource
+		current   []appv1.Resource
+		want      []appv1.Resource
+	}{
+		{
+			name:      "unchanged",
+			resources: []appv1.Resource{pdb, role},
+			current:   []appv1.Resource{pdb, role},
+		},
+		{
+			name:      "version changed",
+			resources: []appv1.Resource{withVersion(pdb, "v1"), role},
+			current:   []appv1.Resource{pdb, role},
+		},
+		{
+			name:      "UID changed",
+			resources: []appv1.Resource{pdb, withUID(role, "uid-2")},
+			current:   []appv1.Resource{pdb, role},
+		},
+		{
+			name:      "UID not recorded",
+			resources: []appv1.Resource{pdb, withUID(role, "")},
+			current:   []appv1.Resource{pdb, role},
+		},
+		{
+			name:      "removed",
+			resources: []appv1.Resource{pdb},
+			current:   []appv1.Resource{pdb, role},
+			want:      []appv1.Resource{role},
+		},
+		{
+			name:      "renamed",
+			resources: []appv1.Resource{withName(pdb, "api"), role},
+			current:   []appv1.Resource{pdb, role},
+			want:      []appv1.Resource{pdb},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getDeletedResources(tt.resources, tt.current); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getDeletedResources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
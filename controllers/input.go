@@ -0,0 +1,76 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/version"
+)
+
+// inputDigest returns the digest of everything the manifests are rendered from, the chart content
+// is included because a chart path like a tag or an unversioned URL may point to a new chart
+func inputDigest(cr *appv1.HelmChart, ch *chart.Chart) string {
+	data := []byte(cr.Spec.Chart.Path + "\n" + version.Version + "\n" + chartDigest(ch) + "\n")
+	data = append(data, cr.Spec.Values.Raw...)
+
+	return digest(data)
+}
+
+// chartDigest returns the digest of the files in a chart, including its default values and dependencies
+func chartDigest(ch *chart.Chart) string {
+	if ch == nil {
+		return ""
+	}
+
+	files := make([]*chart.File, len(ch.Raw))
+	copy(files, ch.Raw)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	var data []byte
+	for _, f := range files {
+		data = append(data, []byte(f.Name+"\n")...)
+		data = append(data, []byte(digest(f.Data)+"\n")...)
+	}
+
+	return digest(data)
+}
+
+// inputsUnchanged returns whether the current revision is rendered from the same inputs
+// and applied successfully, so only the drift needs to be checked
+func inputsUnchanged(cr *appv1.HelmChart, inputs string) bool {
+	if cr.Status.Revision == 0 || cr.Status.InputDigest != inputs {
+		return false
+	}
+
+	if cr.Status.ObservedGeneration != cr.Generation || cr.Spec.DryRun {
+		return false
+	}
+
+	if target, err := rollbackTarget(cr); err != nil || target > 0 {
+		return false
+	}
+
+	ready := meta.FindStatusCondition(cr.Status.Conditions, appv1.ReadyCondition)
+	return ready != nil && ready.Reason != appv1.ReasonFailed
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Results of applying a resource
+const (
+	applySucceeded = "success"
+	applyFailed    = "error"
+	applyConflict  = "conflict"
+)
+
+// applyDuration is the latency of applying a resource, including the retries on conflicts and recreating
+var applyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "helmchart_apply_duration_seconds",
+	Help:    "Duration of applying a resource of a HelmChart in seconds.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"kind", "result"})
+
+func init() {
+	metrics.Registry.MustRegister(applyDuration)
+}
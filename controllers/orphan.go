@@ -0,0 +1,208 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/constant"
+	"github.com/chenzhiwei/helm-operator/utils/yaml"
+)
+
+// orphanResources removes the owner references and tracking labels from the resources of the HelmChart,
+// and removes the HelmDog without deleting the resources tracked by it. The resources are found by the
+// owner references in the namespace, the HelmDog and the current revision, so the resources are orphaned
+// even if no revision is recorded.
+func (r *HelmChartReconciler) orphanResources(ctx context.Context, cr *appv1.HelmChart) error {
+	helmDog := &appv1.HelmDog{}
+	if err := r.Get(ctx, types.NamespacedName{Name: cr.Name, Namespace: cr.Namespace}, helmDog); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		helmDog = nil
+	}
+
+	objects, err := r.ownedObjects(ctx, cr)
+	if err != nil {
+		return err
+	}
+	tracked, err := r.trackedObjects(ctx, cr, helmDog)
+	if err != nil {
+		return err
+	}
+	objects = append(objects, tracked...)
+
+	orphaned := 0
+	seen := map[string]bool{}
+	for _, live := range objects {
+		if seen[objectKey(live)] {
+			continue
+		}
+		seen[objectKey(live)] = true
+
+		patch := client.MergeFrom(live.DeepCopy())
+		var refs []metav1.OwnerReference
+		for _, ref := range live.GetOwnerReferences() {
+			if ref.UID != cr.UID {
+				refs = append(refs, ref)
+			}
+		}
+		live.SetOwnerReferences(refs)
+		labels := live.GetLabels()
+		if labels[constant.HelmChartNameLabel] == cr.Name && labels[constant.HelmChartNamespaceLabel] == cr.Namespace {
+			delete(labels, constant.HelmChartNameLabel)
+			delete(labels, constant.HelmChartNamespaceLabel)
+			live.SetLabels(labels)
+		}
+		removeReference(cr.Namespace, cr.Name, live)
+
+		if err := r.Patch(ctx, live, patch); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		orphaned++
+	}
+
+	// the HelmDog deletes the resources on deletion unless its finalizer is removed first
+	if helmDog != nil {
+		if len(helmDog.GetFinalizers()) > 0 {
+			helmDog.SetFinalizers(nil)
+			if err := r.Update(ctx, helmDog); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+		if err := r.cleanResources(ctx, cr); err != nil {
+			return err
+		}
+	}
+
+	r.Recorder.Eventf(cr, corev1.EventTypeNormal, eventOrphaned, "orphaned %d resources", orphaned)
+
+	return nil
+}
+
+// ownedObjects lists the objects of every kind in the namespace of the HelmChart with its owner reference
+func (r *HelmChartReconciler) ownedObjects(ctx context.Context, cr *appv1.HelmChart) ([]*unstructured.Unstructured, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	lists, err := r.discovery.ServerPreferredNamespacedResources()
+	if err != nil {
+		// the kinds of the unavailable API groups are not orphaned
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, err
+		}
+		log.Error(err, "failed to discover some API groups")
+	}
+	lists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "patch"}}, lists)
+
+	var result []*unstructured.Unstructured
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range list.APIResources {
+			// skip the subresources
+			if strings.Contains(res.Name, "/") {
+				continue
+			}
+
+			objs := &unstructured.UnstructuredList{}
+			objs.SetGroupVersionKind(gv.WithKind(res.Kind + "List"))
+			if err := r.List(ctx, objs, client.InNamespace(cr.Namespace)); err != nil {
+				if errors.IsNotFound(err) || errors.IsMethodNotSupported(err) {
+					continue
+				}
+				return nil, err
+			}
+			for i := range objs.Items {
+				obj := &objs.Items[i]
+				for _, ref := range obj.GetOwnerReferences() {
+					if ref.UID == cr.UID {
+						result = append(result, obj)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// trackedObjects gets the live objects tracked by the HelmDog and the objects of the current revision
+func (r *HelmChartReconciler) trackedObjects(ctx context.Context, cr *appv1.HelmChart, helmDog *appv1.HelmDog) ([]*unstructured.Unstructured, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	var objects []*unstructured.Unstructured
+	if helmDog != nil {
+		for _, res := range helmDog.Spec.Resources {
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(schema.GroupVersionKind{Group: res.Group, Version: res.Version, Kind: res.Kind})
+			obj.SetName(res.Name)
+			obj.SetNamespace(res.Namespace)
+			objects = append(objects, obj)
+		}
+	}
+
+	if cr.Status.Revision > 0 {
+		rel, err := r.loadRevision(ctx, cr, cr.Status.Revision)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if rel != nil {
+			for _, m := range rel.manifests {
+				obj, err := yaml.YamlToObject(m)
+				if err != nil {
+					return nil, err
+				}
+				if err := r.prepareObject(cr, obj); err != nil {
+					log.Error(err, "failed to prepare the object for orphaning", "kind", obj.GetKind(), "name", obj.GetName())
+					continue
+				}
+				objects = append(objects, obj)
+			}
+		}
+	}
+
+	var result []*unstructured.Unstructured
+	for _, obj := range objects {
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(obj.GroupVersionKind())
+		if err := r.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, live); err != nil {
+			if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, err
+		}
+		result = append(result, live)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	k8syaml "sigs.k8s.io/yaml"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils"
+	"github.com/chenzhiwei/helm-operator/utils/diff"
+	"github.com/chenzhiwei/helm-operator/utils/pointer"
+	"github.com/chenzhiwei/helm-operator/utils/yaml"
+)
+
+// the ConfigMap data is limited to 1MiB
+const maxPlanSize = 512 * 1024
+
+// plan runs server side apply in dry-run mode for every object in the release,
+// and stores the diff between the live and the planned objects in a ConfigMap
+func (r *HelmChartReconciler) plan(ctx context.Context, cr *appv1.HelmChart, rel *release) (*appv1.Plan, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	lastApplied, err := r.lastAppliedManifests(ctx, cr)
+	if err != nil {
+		// the removed resources are unknown without the current revision
+		log.Error(err, "failed to get the last applied manifests")
+		lastApplied = map[string][]byte{}
+	}
+
+	p := &appv1.Plan{
+		Digest:       rel.digest(),
+		ChartVersion: rel.chartVersion,
+		ValuesHash:   rel.valuesHash,
+		ConfigMap:    utils.PlanConfigMapName(cr.Name),
+		PlannedAt:    metav1.Now(),
+	}
+
+	var diffs []string
+	desired := map[string]bool{}
+	for _, m := range rel.manifests {
+		obj, err := yaml.YamlToObject(m)
+		if err != nil {
+			return nil, err
+		}
+		desired[objectKey(obj)] = true
+
+		if isCRD(obj) && getCRDApplyPolicy(cr) == appv1.CRDSkip {
+			continue
+		}
+
+		if err := r.prepareObject(cr, obj); err != nil {
+			// the custom resources can not be mapped before the CRD is created
+			if meta.IsNoMatchError(err) {
+				p.Added++
+				diffs = append(diffs, diff.Unified("", normalizedYaml(obj), "/dev/null", objectName(obj)))
+				continue
+			}
+			return nil, err
+		}
+
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(obj.GroupVersionKind())
+		if err := r.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, live); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, err
+			}
+			live = nil
+		}
+
+		patchOptions := &client.PatchOptions{
+			FieldManager: helmChartFieldManager(cr),
+			Force:        pointer.Bool(true),
+			DryRun:       []string{metav1.DryRunAll},
+		}
+		if err := r.Patch(ctx, obj, client.Apply, patchOptions); err != nil {
+			return nil, fmt.Errorf("failed to dry-run %s: %w", objectName(obj), err)
+		}
+
+		from, fromName := "", "/dev/null"
+		if live != nil {
+			from, fromName = normalizedYaml(live), "live/"+objectName(obj)
+		}
+		d := diff.Unified(from, normalizedYaml(obj), fromName, "planned/"+objectName(obj))
+		if d == "" {
+			continue
+		}
+
+		if live == nil {
+			p.Added++
+		} else {
+			p.Changed++
+		}
+		diffs = append(diffs, d)
+	}
+
+	var removed []string
+	for key := range lastApplied {
+		if !desired[key] {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		obj, err := yaml.YamlToObject(lastApplied[key])
+		if err != nil {
+			return nil, err
+		}
+		p.Removed++
+		diffs = append(diffs, diff.Unified(normalizedYaml(obj), "", "live/"+objectName(obj), "/dev/null"))
+	}
+
+	content := strings.Join(diffs, "\n")
+	if len(content) > maxPlanSize {
+		content = content[:maxPlanSize] + "\n... truncated\n"
+	}
+
+	cm := &corev1.ConfigMap{}
+	cm.SetName(p.ConfigMap)
+	cm.SetNamespace(cr.Namespace)
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Data = map[string]string{
+			"digest": p.Digest,
+			"diff":   content,
+		}
+		return controllerutil.SetControllerReference(cr, cm, r.Scheme)
+	}); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func objectName(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetKind() + "/" + obj.GetName()
+	}
+
+	return obj.GetKind() + "/" + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// normalizedYaml removes the fields set by the API server which are not interesting in a diff,
+// and redacts the data of Secrets because the diff is readable by anyone who can read ConfigMaps
+func normalizedYaml(obj *unstructured.Unstructured) string {
+	o := obj.DeepCopy()
+	o.SetManagedFields(nil)
+	o.SetResourceVersion("")
+	o.SetGeneration(0)
+	o.SetUID("")
+	o.SetSelfLink("")
+	o.SetCreationTimestamp(metav1.Time{})
+	unstructured.RemoveNestedField(o.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(o.Object, "status")
+	if o.GroupVersionKind().GroupKind() == (schema.GroupKind{Kind: "Secret"}) {
+		redactSecret(o)
+	}
+
+	data, err := k8syaml.Marshal(o.Object)
+	if err != nil {
+		return err.Error()
+	}
+
+	return string(data)
+}
+
+// redactSecret replaces the values of a Secret with a short hash of them, so the changed keys
+// are still visible in the diff
+func redactSecret(obj *unstructured.Unstructured) {
+	for _, field := range []string{"data", "stringData"} {
+		data, ok := obj.Object[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for k, v := range data {
+			data[k] = fmt.Sprintf("(redacted, sha256:%s)", digest([]byte(fmt.Sprint(v)))[:8])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/constant"
+	"github.com/chenzhiwei/helm-operator/utils/pointer"
+)
+
+const recreateTimeout = 30 * time.Second
+
+// the messages of the validation errors on immutable fields
+var immutableMessages = []string{"field is immutable", "may not change once set"}
+
+// isImmutableError returns whether the apply is rejected because of changing immutable fields
+func isImmutableError(err error) bool {
+	if !errors.IsInvalid(err) {
+		return false
+	}
+
+	msg := err.Error()
+	if status, ok := err.(errors.APIStatus); ok && status.Status().Details != nil {
+		for _, cause := range status.Status().Details.Causes {
+			msg += "\n" + cause.Message
+		}
+	}
+
+	for _, m := range immutableMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recreateEnabled returns whether the object can be recreated, the annotation on the object overrides the HelmChart
+func recreateEnabled(cr *appv1.HelmChart, obj *unstructured.Unstructured) bool {
+	if v, ok := obj.GetAnnotations()[constant.RecreateAnnotation]; ok {
+		if recreate, err := strconv.ParseBool(v); err == nil {
+			return recreate
+		}
+	}
+
+	return cr.Spec.Upgrade != nil && cr.Spec.Upgrade.Recreate
+}
+
+// recreate deletes the object and waits until it is gone, then applies it again
+func (r *HelmChartReconciler) recreate(ctx context.Context, cr *appv1.HelmChart, obj *unstructured.Unstructured, patchOptions *client.PatchOptions) error {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+	log.Info("recreating the object with immutable fields changed", "kind", obj.GetKind(), "name", obj.GetName(), "namespace", obj.GetNamespace())
+
+	old := &unstructured.Unstructured{}
+	old.SetGroupVersionKind(obj.GroupVersionKind())
+	old.SetName(obj.GetName())
+	old.SetNamespace(obj.GetNamespace())
+	if err := r.Delete(ctx, old, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete for recreating: %w", err)
+	}
+
+	key := types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}
+	err := wait.PollImmediateWithContext(ctx, time.Second, recreateTimeout, func(ctx context.Context) (bool, error) {
+		if err := r.Get(ctx, key, old); err != nil {
+			if errors.IsNotFound(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to wait for the deletion: %w", err)
+	}
+
+	patchOptions.Force = pointer.Bool(true)
+	if err := r.Patch(ctx, obj, client.Apply, patchOptions); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(cr, corev1.EventTypeNormal, eventRecreated, "%s %s is recreated", obj.GetKind(), obj.GetName())
+
+	return nil
+}
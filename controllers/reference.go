@@ -0,0 +1,91 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/constant"
+)
+
+// A shared resource has annotation app.siji.io/shared, every HelmChart applying it adds a reference annotation
+// ref.app.siji.io/<hash>: <namespace>/<name> with its own field manager, and the resource is deleted only when
+// the last reference is removed. A shared resource has no owner references because the garbage collector
+// does not know the references, it is tracked by the HelmDog of every HelmChart even in the same namespace.
+
+func isShared(obj client.Object) bool {
+	_, ok := obj.GetAnnotations()[constant.SharedAnnotation]
+	return ok
+}
+
+// trackedByHelmDog returns whether a resource is deleted by HelmDog instead of the garbage collector
+func trackedByHelmDog(cr *appv1.HelmChart, obj client.Object) bool {
+	return obj.GetNamespace() != cr.Namespace || isShared(obj)
+}
+
+// referenceKey returns the annotation key of the reference of a HelmChart,
+// the name part of an annotation key is limited to 63 characters
+func referenceKey(namespace, name string) string {
+	return constant.ReferenceAnnotationPrefix + digest([]byte(namespace + "/" + name))[:16]
+}
+
+func setReference(namespace, name string, obj client.Object) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[referenceKey(namespace, name)] = namespace + "/" + name
+	obj.SetAnnotations(annotations)
+}
+
+// removeReference removes the reference of a HelmChart, and returns whether it is removed
+func removeReference(namespace, name string, obj client.Object) bool {
+	annotations := obj.GetAnnotations()
+	key := referenceKey(namespace, name)
+	if _, ok := annotations[key]; !ok {
+		return false
+	}
+
+	delete(annotations, key)
+	obj.SetAnnotations(annotations)
+	return true
+}
+
+// references returns the HelmCharts referencing a resource
+func references(obj client.Object) []types.NamespacedName {
+	var refs []types.NamespacedName
+	for key, value := range obj.GetAnnotations() {
+		if !strings.HasPrefix(key, constant.ReferenceAnnotationPrefix) {
+			continue
+		}
+		parts := strings.SplitN(value, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		refs = append(refs, types.NamespacedName{Namespace: parts[0], Name: parts[1]})
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].String() < refs[j].String()
+	})
+
+	return refs
+}
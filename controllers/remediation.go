@@ -0,0 +1,102 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+)
+
+const defaultRemediationRetries = 3
+
+// remediate returns the release to apply. It is the desired release unless applying it failed more
+// times than the remediation retries, then it is the last good revision for the Rollback strategy,
+// or nil for the None strategy which means nothing should be applied.
+func (r *HelmChartReconciler) remediate(ctx context.Context, cr *appv1.HelmChart, rel *release) (*release, error) {
+	dgst := rel.digest()
+	if cr.Status.LastAttemptedDigest != dgst {
+		cr.Status.LastAttemptedDigest = dgst
+		cr.Status.Failures = 0
+	}
+
+	remediation := getRemediation(cr)
+	if remediation == nil || cr.Status.Failures <= getRetries(remediation) {
+		meta.RemoveStatusCondition(&cr.Status.Conditions, appv1.RemediatedCondition)
+		return rel, nil
+	}
+
+	if remediation.Strategy == appv1.RemediationNone || cr.Status.Revision == 0 {
+		msg := fmt.Sprintf("failed to apply the manifests %d times, leaving the resources as they are", cr.Status.Failures)
+		r.Recorder.Event(cr, corev1.EventTypeWarning, eventRemediated, msg)
+		meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
+			Type:               appv1.RemediatedCondition,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: cr.Generation,
+			Reason:             appv1.ReasonRetries,
+			Message:            msg,
+		})
+		return nil, nil
+	}
+
+	last, err := r.loadRevision(ctx, cr, cr.Status.Revision)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := fmt.Sprintf("failed to apply the manifests %d times, rolled back to revision %d", cr.Status.Failures, last.revision)
+	r.Recorder.Event(cr, corev1.EventTypeWarning, eventRemediated, msg)
+	meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
+		Type:               appv1.RemediatedCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: cr.Generation,
+		Reason:             appv1.ReasonRollback,
+		Message:            msg,
+	})
+
+	return last, nil
+}
+
+// recordApplyFailure counts the failure of applying the desired release and records the error
+func (r *HelmChartReconciler) recordApplyFailure(ctx context.Context, cr *appv1.HelmChart, rel *release, err error) {
+	if rel.revision == 0 {
+		cr.Status.Failures++
+	}
+
+	r.setFailedStatus(ctx, cr, err)
+}
+
+func getRemediation(cr *appv1.HelmChart) *appv1.Remediation {
+	if cr.Spec.Upgrade == nil {
+		return nil
+	}
+
+	return cr.Spec.Upgrade.Remediation
+}
+
+func getRetries(remediation *appv1.Remediation) int32 {
+	if remediation.Retries != nil {
+		return *remediation.Retries
+	}
+
+	return defaultRemediationRetries
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils"
+	"github.com/chenzhiwei/helm-operator/utils/constant"
+)
+
+const defaultHistoryLimit = 10
+
+// the Secret data is limited to 1MiB, leave some room for the metadata
+const maxRevisionSize = 1024*1024 - 16*1024
+
+var manifestsSeparator = []byte("\n---\n")
+
+// release is the manifests to be applied for a HelmChart
+type release struct {
+	manifests    [][]byte
+	chartVersion string
+	valuesHash   string
+
+	// revision is set when the manifests are loaded from a stored revision
+	revision int64
+	// force records a new revision even if the manifests are the same as the current revision,
+	// because the current revision is lost
+	force bool
+}
+
+func (rel *release) digest() string {
+	return digest(joinManifests(rel.manifests))
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func joinManifests(manifests [][]byte) []byte {
+	return bytes.Join(manifests, manifestsSeparator)
+}
+
+func splitManifests(data []byte) [][]byte {
+	return bytes.Split(data, manifestsSeparator)
+}
+
+// compressManifests gzips the manifests like Helm does for its releases
+func compressManifests(manifests [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(joinManifests(manifests)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompressManifests reads the manifests of a revision, the revisions recorded before are not compressed
+func decompressManifests(data []byte) ([][]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return splitManifests(data), nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitManifests(raw), nil
+}
+
+// rollbackTarget returns the revision which the HelmChart is rolled back to, 0 means not rolled back
+func rollbackTarget(cr *appv1.HelmChart) (int64, error) {
+	if cr.Spec.RollbackTo > 0 {
+		return cr.Spec.RollbackTo, nil
+	}
+
+	value, ok := cr.GetAnnotations()[constant.RollbackToAnnotation]
+	if !ok || value == "" {
+		return 0, nil
+	}
+
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || revision < 0 {
+		return 0, fmt.Errorf("invalid annotation %s: %q", constant.RollbackToAnnotation, value)
+	}
+
+	return revision, nil
+}
+
+// loadRevision reads the manifests of a stored revision
+func (r *HelmChartReconciler) loadRevision(ctx context.Context, cr *appv1.HelmChart, revision int64) (*release, error) {
+	secret := &corev1.Secret{}
+	namespacedName := types.NamespacedName{
+		Name:      utils.RevisionSecretName(cr.Name, revision),
+		Namespace: cr.Namespace,
+	}
+	if err := r.Get(ctx, namespacedName, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("revision %d is not found: %w", revision, err)
+		}
+		return nil, err
+	}
+
+	mBytes, ok := secret.Data["manifests"]
+	if !ok {
+		return nil, fmt.Errorf("revision %d secret format is incorrect", revision)
+	}
+	manifests, err := decompressManifests(mBytes)
+	if err != nil {
+		return nil, fmt.Errorf("revision %d secret format is incorrect: %w", revision, err)
+	}
+
+	return &release{
+		manifests:    manifests,
+		chartVersion: secret.Annotations[constant.ChartVersionAnnotation],
+		valuesHash:   secret.Annotations[constant.ValuesHashAnnotation],
+		revision:     revision,
+	}, nil
+}
+
+// recordRevision stores the applied manifests as a new revision if they are changed,
+// and removes the revisions exceeding the history limit
+func (r *HelmChartReconciler) recordRevision(ctx context.Context, cr *appv1.HelmChart, rel *release) error {
+	if rel.revision > 0 {
+		cr.Status.Revision = rel.revision
+		return nil
+	}
+
+	dgst := rel.digest()
+	var latest int64
+	for _, h := range cr.Status.History {
+		if h.Revision == cr.Status.Revision && h.Digest == dgst && !rel.force {
+			return nil
+		}
+		if h.Revision > latest {
+			latest = h.Revision
+		}
+	}
+
+	data, err := compressManifests(rel.manifests)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxRevisionSize {
+		return fmt.Errorf("the manifests are %d bytes after compression, exceeding the limit %d bytes of a revision Secret", len(data), maxRevisionSize)
+	}
+
+	revision := appv1.Revision{
+		Revision:     latest + 1,
+		ChartVersion: rel.chartVersion,
+		ValuesHash:   rel.valuesHash,
+		Digest:       dgst,
+		AppliedAt:    metav1.Now(),
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      utils.RevisionSecretName(cr.Name, revision.Revision),
+			Namespace: cr.Namespace,
+			Labels: map[string]string{
+				constant.HelmChartLabel: cr.Name,
+				constant.RevisionLabel:  strconv.FormatInt(revision.Revision, 10),
+			},
+			Annotations: map[string]string{
+				constant.ChartVersionAnnotation: revision.ChartVersion,
+				constant.ValuesHashAnnotation:   revision.ValuesHash,
+			},
+		},
+		Type: "app.siji.io/revision",
+		Data: map[string][]byte{
+			"manifests": data,
+		},
+	}
+	if err := controllerutil.SetControllerReference(cr, secret, r.Scheme); err != nil {
+		return err
+	}
+
+	if err := r.Create(ctx, secret); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return err
+		}
+		// the status was not updated after creating the secret last time
+		if err := r.Update(ctx, secret); err != nil {
+			return err
+		}
+	}
+
+	cr.Status.History = append(cr.Status.History, revision)
+	cr.Status.Revision = revision.Revision
+
+	return r.pruneRevisions(ctx, cr)
+}
+
+func (r *HelmChartReconciler) pruneRevisions(ctx context.Context, cr *appv1.HelmChart) error {
+	limit := defaultHistoryLimit
+	if cr.Spec.HistoryLimit != nil {
+		limit = int(*cr.Spec.HistoryLimit)
+	}
+
+	for len(cr.Status.History) > limit {
+		oldest := cr.Status.History[0]
+		secret := &corev1.Secret{}
+		secret.SetName(utils.RevisionSecretName(cr.Name, oldest.Revision))
+		secret.SetNamespace(cr.Namespace)
+		if err := r.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		cr.Status.History = cr.Status.History[1:]
+	}
+
+	return nil
+}
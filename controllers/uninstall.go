@@ -0,0 +1,138 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+)
+
+// the margin after the grace period for the HelmDog to remove the finalizers
+const uninstallTimeoutMargin = time.Minute
+
+// getUninstallTimeout returns the time to wait for the resources to be deleted, it returns false
+// if the HelmChart waits until the finalizers of the resources are removed by their controllers
+func getUninstallTimeout(cr *appv1.HelmChart) (time.Duration, bool) {
+	if cr.Spec.Uninstall == nil {
+		return defaultGracePeriod + uninstallTimeoutMargin, true
+	}
+	if cr.Spec.Uninstall.Timeout != nil {
+		return cr.Spec.Uninstall.Timeout.Duration, true
+	}
+
+	gracePeriod := defaultGracePeriod
+	if cr.Spec.Uninstall.GracePeriod != nil {
+		gracePeriod = cr.Spec.Uninstall.GracePeriod.Duration
+	}
+	longest := gracePeriod
+	for _, p := range cr.Spec.Uninstall.FinalizerPolicies {
+		if p.Action == appv1.FinalizerWait {
+			return 0, false
+		}
+		if p.GracePeriod != nil && p.GracePeriod.Duration > longest {
+			longest = p.GracePeriod.Duration
+		}
+	}
+
+	return longest + uninstallTimeoutMargin, true
+}
+
+// uninstall deletes the resources of a deleted HelmChart, and keeps it Terminating until the HelmDog
+// has deleted all the tracked resources, or the timeout is reached
+func (r *HelmChartReconciler) uninstall(ctx context.Context, cr *appv1.HelmChart) (ctrl.Result, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	timeout, ok := getUninstallTimeout(cr)
+	timedOut := ok && time.Since(cr.DeletionTimestamp.Time) > timeout
+
+	if !timedOut {
+		done, err := r.deleteWaves(ctx, cr)
+		if err != nil {
+			log.Error(err, "failed to delete the resources by waves")
+			r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventCleanupFailed, "failed to delete the resources by waves: %s", err)
+			return ctrl.Result{}, err
+		}
+		if !done {
+			log.V(1).Info("waiting for the resources of a wave to be deleted")
+			return r.setTerminatingStatus(ctx, cr, "waiting for the resources of a wave to be deleted")
+		}
+	}
+
+	helmDog := &appv1.HelmDog{}
+	if err := r.Get(ctx, types.NamespacedName{Name: cr.Name, Namespace: cr.Namespace}, helmDog); err != nil {
+		if !errors.IsNotFound(err) {
+			log.Error(err, "failed to get HelmDog")
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Event(cr, corev1.EventTypeNormal, eventUninstalled, "all the resources are deleted")
+		return r.removeFinalizer(ctx, cr)
+	}
+
+	// delete resources in other namespaces or cluster scoped resources
+	if helmDog.DeletionTimestamp == nil {
+		if err := r.cleanResources(ctx, cr); err != nil {
+			log.Error(err, "failed to clean extra resources", "HelmDog", cr.Name)
+			r.Recorder.Eventf(cr, corev1.EventTypeWarning, eventCleanupFailed, "failed to delete HelmDog: %s", err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	if timedOut {
+		msg := fmt.Sprintf("timed out waiting for HelmDog %s to delete %d resources, it keeps deleting them", helmDog.Name, len(helmDog.Spec.Resources))
+		log.Info(msg)
+		r.Recorder.Event(cr, corev1.EventTypeWarning, eventTimeout, msg)
+		return r.removeFinalizer(ctx, cr)
+	}
+
+	msg := fmt.Sprintf("waiting for HelmDog %s to delete %d resources", helmDog.Name, len(helmDog.Spec.Resources))
+	if deleting := countPhase(helmDog.Status.ResourceStates, appv1.ResourceDeleting); deleting > 0 {
+		msg += fmt.Sprintf(", %d of them are waiting for finalizers", deleting)
+	}
+	if failed := countPhase(helmDog.Status.ResourceStates, appv1.ResourceFailed); failed > 0 {
+		msg += fmt.Sprintf(", %d of them failed", failed)
+	}
+	return r.setTerminatingStatus(ctx, cr, msg)
+}
+
+func (r *HelmChartReconciler) setTerminatingStatus(ctx context.Context, cr *appv1.HelmChart, msg string) (ctrl.Result, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	cr.Status.Phase = appv1.PhaseTerminating
+	meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
+		Type:               appv1.ReadyCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: cr.Generation,
+		Reason:             appv1.ReasonTerminating,
+		Message:            msg,
+	})
+	if err := r.Status().Update(ctx, cr); err != nil {
+		log.Error(err, "failed to update HelmChart status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: healthCheckInterval}, nil
+}
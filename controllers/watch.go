@@ -0,0 +1,144 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/constant"
+)
+
+// setTrackingLabels sets the labels of the HelmChart on a resource without owner reference
+func setTrackingLabels(cr *appv1.HelmChart, obj client.Object) {
+	// the label value is limited to 63 characters
+	if len(validation.IsValidLabelValue(cr.Name)) > 0 {
+		return
+	}
+
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[constant.HelmChartNameLabel] = cr.Name
+	labels[constant.HelmChartNamespaceLabel] = cr.Namespace
+	obj.SetLabels(labels)
+}
+
+// isTracked returns whether the live object has the tracking labels and references of the desired object
+func isTracked(desired, live client.Object) bool {
+	for _, key := range []string{constant.HelmChartNameLabel, constant.HelmChartNamespaceLabel} {
+		if desired.GetLabels()[key] != live.GetLabels()[key] {
+			return false
+		}
+	}
+
+	for key, value := range desired.GetAnnotations() {
+		if strings.HasPrefix(key, constant.ReferenceAnnotationPrefix) && live.GetAnnotations()[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// trackedHelmChart maps a resource to the HelmCharts in its tracking labels and references
+func trackedHelmChart(obj client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+
+	labels := obj.GetLabels()
+	name, namespace := labels[constant.HelmChartNameLabel], labels[constant.HelmChartNamespaceLabel]
+	if name != "" && namespace != "" {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: name, Namespace: namespace},
+		})
+	}
+
+	for _, ref := range references(obj) {
+		requests = append(requests, reconcile.Request{NamespacedName: ref})
+	}
+
+	return requests
+}
+
+// markWatched records the kinds watched with typed informers in SetupWithManager
+func (r *HelmChartReconciler) markWatched(objects ...client.Object) error {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+
+	if r.watched == nil {
+		r.watched = map[schema.GroupVersionKind]bool{}
+	}
+	for _, obj := range objects {
+		gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+		if err != nil {
+			return err
+		}
+		r.watched[gvk] = true
+	}
+
+	return nil
+}
+
+// watchKinds starts metadata-only watches for the kinds of the applied objects which are not watched yet,
+// the events are mapped to the HelmChart owning or tracking the object
+func (r *HelmChartReconciler) watchKinds(objects []*unstructured.Unstructured) {
+	log := ctrl.Log.WithName("controller.helmchart")
+
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+
+	if r.controller == nil {
+		return
+	}
+
+	for _, obj := range objects {
+		gvk := obj.GroupVersionKind()
+		if r.watched[gvk] {
+			continue
+		}
+
+		metadata := &metav1.PartialObjectMetadata{}
+		metadata.SetGroupVersionKind(gvk)
+		src := source.NewKindWithCache(metadata, r.cache)
+		owner := &handler.EnqueueRequestForOwner{OwnerType: &appv1.HelmChart{}, IsController: true}
+		if err := r.controller.Watch(src, owner); err != nil {
+			log.Error(err, "failed to watch the kind", "group", gvk.Group, "version", gvk.Version, "kind", gvk.Kind)
+			continue
+		}
+		// the informer is shared with the watch above
+		if err := r.controller.Watch(source.NewKindWithCache(metadata, r.cache), handler.EnqueueRequestsFromMapFunc(trackedHelmChart)); err != nil {
+			log.Error(err, "failed to watch the kind", "group", gvk.Group, "version", gvk.Version, "kind", gvk.Kind)
+			continue
+		}
+
+		log.Info("watching the kind", "group", gvk.Group, "version", gvk.Version, "kind", gvk.Kind)
+		r.watched[gvk] = true
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
+	"github.com/chenzhiwei/helm-operator/utils/constant"
+	"github.com/chenzhiwei/helm-operator/utils/yaml"
+)
+
+// waveOf returns the wave of an object from annotation app.siji.io/wave, defaults to 0
+func waveOf(obj *unstructured.Unstructured) (int, error) {
+	value, ok := obj.GetAnnotations()[constant.WaveAnnotation]
+	if !ok || value == "" {
+		return 0, nil
+	}
+
+	wave, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid annotation %s of %s: %q", constant.WaveAnnotation, objectName(obj), value)
+	}
+
+	return wave, nil
+}
+
+// splitWaves groups the manifests by wave in ascending order, the order in a wave is kept
+func splitWaves(manifests [][]byte) ([][][]byte, error) {
+	type waveManifest struct {
+		wave     int
+		manifest []byte
+	}
+
+	items := make([]waveManifest, 0, len(manifests))
+	for _, m := range manifests {
+		obj, err := yaml.YamlToObject(m)
+		if err != nil {
+			return nil, err
+		}
+		wave, err := waveOf(obj)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, waveManifest{wave: wave, manifest: m})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].wave < items[j].wave
+	})
+
+	var waves [][][]byte
+	for i, item := range items {
+		if i == 0 || item.wave != items[i-1].wave {
+			waves = append(waves, nil)
+		}
+		waves[len(waves)-1] = append(waves[len(waves)-1], item.manifest)
+	}
+
+	return waves, nil
+}
+
+// applyWaves applies the waves in order, and returns the waves not applied because
+// the previous wave is not healthy yet
+func (r *HelmChartReconciler) applyWaves(ctx context.Context, cr *appv1.HelmChart, st *applyState, waves [][][]byte) ([][][]byte, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	for i, wave := range waves {
+		start := len(st.objects)
+		if err := r.applyManifests(ctx, cr, st, wave); err != nil {
+			return nil, err
+		}
+		if i == len(waves)-1 {
+			break
+		}
+
+		healthy, msg, err := checkHealth(st.objects[start:])
+		if err != nil {
+			return nil, err
+		}
+		if !healthy {
+			log.V(1).Info("waiting for the wave to become ready", "wave", i, "reason", msg)
+			return waves[i+1:], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// trackManifests records the resources to be tracked by HelmDog without applying them,
+// so the resources of the pending waves are not pruned
+func (r *HelmChartReconciler) trackManifests(cr *appv1.HelmChart, st *applyState, waves [][][]byte) error {
+	for _, wave := range waves {
+		for _, m := range wave {
+			obj, err := yaml.YamlToObject(m)
+			if err != nil {
+				return err
+			}
+			if err := r.prepareObject(cr, obj); err != nil {
+				return err
+			}
+			if trackedByHelmDog(cr, obj) {
+				st.resources = append(st.resources, r.trackedResource(obj))
+			}
+		}
+	}
+
+	return nil
+}
+
+// ownedBy returns whether the live object is controlled or tracked by the HelmChart
+func ownedBy(cr *appv1.HelmChart, live *unstructured.Unstructured) bool {
+	if owner := metav1.GetControllerOf(live); owner != nil {
+		return owner.UID == cr.UID
+	}
+
+	labels := live.GetLabels()
+	return labels[constant.HelmChartNameLabel] == cr.Name && labels[constant.HelmChartNamespaceLabel] == cr.Namespace
+}
+
+// deleteWaves deletes the resources of the current revision in the reverse wave order,
+// it returns false when the resources of a wave are still being deleted
+func (r *HelmChartReconciler) deleteWaves(ctx context.Context, cr *appv1.HelmChart) (bool, error) {
+	log := ctrl.Log.WithName("controller.helmchart").WithValues("HelmChart", cr.Name+"/"+cr.Namespace)
+
+	if cr.Status.Revision == 0 {
+		return true, nil
+	}
+
+	rel, err := r.loadRevision(ctx, cr, cr.Status.Revision)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	_, manifests, err := splitCRDs(rel.manifests)
+	if err != nil {
+		return false, err
+	}
+	waves, err := splitWaves(manifests)
+	if err != nil {
+		return false, err
+	}
+
+	// the resources in a single wave are deleted by HelmDog and garbage collector
+	if len(waves) < 2 {
+		return true, nil
+	}
+
+	// the UIDs of the resources tracked by HelmDog
+	uids := map[string]string{}
+	helmDog := &appv1.HelmDog{}
+	if err := r.Get(ctx, types.NamespacedName{Name: cr.Name, Namespace: cr.Namespace}, helmDog); client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	for _, res := range helmDog.Spec.Resources {
+		uids[res.Group+"/"+res.Kind+"/"+res.Namespace+"/"+res.Name] = res.UID
+	}
+
+	for i := len(waves) - 1; i >= 0; i-- {
+		deleting := false
+		for _, m := range waves[i] {
+			obj, err := yaml.YamlToObject(m)
+			if err != nil {
+				return false, err
+			}
+			if err := r.prepareObject(cr, obj); err != nil {
+				log.Error(err, "failed to prepare the object for deleting", "kind", obj.GetKind(), "name", obj.GetName())
+				continue
+			}
+
+			live := &unstructured.Unstructured{}
+			live.SetGroupVersionKind(obj.GroupVersionKind())
+			if err := r.Get(ctx, types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, live); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return false, err
+			}
+
+			// the shared resources are deleted by HelmDog after the last reference is gone
+			if _, ok := live.GetAnnotations()[constant.KeepAnnotation]; ok || isShared(live) {
+				continue
+			}
+			// the resources of others or recreated by others are not deleted
+			if !ownedBy(cr, live) {
+				continue
+			}
+			if uid := uids[objectKey(live)]; uid != "" && uid != string(live.GetUID()) {
+				continue
+			}
+
+			deleting = true
+			if live.GetDeletionTimestamp() == nil {
+				log.Info("delete Resource", "wave", i, "kind", live.GetKind(), "name", live.GetName(), "namespace", live.GetNamespace())
+				if err := r.Delete(ctx, live, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+					return false, err
+				}
+			}
+		}
+
+		if deleting {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
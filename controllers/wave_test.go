@@ -0,0 +1,78 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func waveManifest(name, wave string) []byte {
+	m := fmt.Sprintf("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: %s\n", name)
+	if wave != "" {
+		m += fmt.Sprintf("  annotations:\n    app.siji.io/wave: %q\n", wave)
+	}
+	return []byte(m)
+}
+
+func TestSplitWaves(t *testing.T) {
+	a, b, c, d := waveManifest("a", ""), waveManifest("b", "1"), waveManifest("c", "-1"), waveManifest("d", "0")
+
+	tests := []struct {
+		name      string
+		manifests [][]byte
+		want      [][][]byte
+		wantErr   bool
+	}{
+		{
+			name: "no manifests",
+		},
+		{
+			name:      "single wave",
+			manifests: [][]byte{a, d},
+			want:      [][][]byte{{a, d}},
+		},
+		{
+			name:      "sorted waves with the order kept",
+			manifests: [][]byte{b, a, c, d},
+			want:      [][][]byte{{c}, {a, d}, {b}},
+		},
+		{
+			name:      "not a number",
+			manifests: [][]byte{a, waveManifest("e", "db")},
+			wantErr:   true,
+		},
+		{
+			name:      "not an integer",
+			manifests: [][]byte{waveManifest("e", "1.5")},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitWaves(tt.manifests)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitWaves() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitWaves() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
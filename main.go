@@ -36,6 +36,7 @@ import (
 	appv1 "github.com/chenzhiwei/helm-operator/api/v1"
 	"github.com/chenzhiwei/helm-operator/controllers"
 	"github.com/chenzhiwei/helm-operator/utils/cert"
+	"github.com/chenzhiwei/helm-operator/utils/event"
 	"github.com/chenzhiwei/helm-operator/utils/yaml"
 	//+kubebuilder:scaffold:imports
 )
@@ -45,6 +46,9 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+// the same event of an object is recorded at most once in this window
+const eventDedupWindow = 10 * time.Minute
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
@@ -56,11 +60,13 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var applyConcurrency int
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&applyConcurrency, "apply-concurrency", 10, "The max number of resources of the same kind applied at the same time for a HelmChart.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -90,8 +96,10 @@ func main() {
 	}
 
 	if err := (&controllers.HelmChartReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		Recorder:         event.NewDedupRecorder(mgr.GetEventRecorderFor("helmchart-controller"), eventDedupWindow),
+		ApplyConcurrency: applyConcurrency,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HelmChart")
 		os.Exit(1)
@@ -109,8 +117,9 @@ func main() {
 		}
 	}
 	if err = (&controllers.HelmDogReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: event.NewDedupRecorder(mgr.GetEventRecorderFor("helmdog-controller"), eventDedupWindow),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HelmDog")
 		os.Exit(1)
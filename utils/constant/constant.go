@@ -2,6 +2,21 @@ package constant
 
 const FinalizerName = "app.siji.io/finalizer"
 
+const KeepAnnotation = "app.siji.io/keep"
+const SharedAnnotation = "app.siji.io/shared"
+const ReferenceAnnotationPrefix = "ref.app.siji.io/"
+const ForceCRDDeleteAnnotation = "app.siji.io/force-crd-delete"
+const WaveAnnotation = "app.siji.io/wave"
+const RecreateAnnotation = "app.siji.io/recreate"
+const RollbackToAnnotation = "app.siji.io/rollback-to"
+const ApproveAnnotation = "app.siji.io/approve"
+const HelmChartLabel = "app.siji.io/helmchart"
+const HelmChartNameLabel = "app.siji.io/helmchart-name"
+const HelmChartNamespaceLabel = "app.siji.io/helmchart-namespace"
+const RevisionLabel = "app.siji.io/revision"
+const ChartVersionAnnotation = "app.siji.io/chart-version"
+const ValuesHashAnnotation = "app.siji.io/values-hash"
+
 const HelmOperatorNamespace = "helm-operator"
 const HelmOperatorServiceName = "helm-operator-webhook-service"
 const HelmOperatorTLSSecretName = "helm-operator-webhook-server-cert"
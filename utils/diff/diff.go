@@ -0,0 +1,130 @@
+package diff
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Fields returns the paths of the fields in expected whose values are different in actual,
+// the fields only in actual are ignored because they are usually defaulted by the API server,
+// and the empty maps and lists in expected are equal to the missing ones because the API server omits them
+func Fields(expected, actual interface{}) []string {
+	return fields("", expected, actual)
+}
+
+func fields(path string, expected, actual interface{}) []string {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		if len(e) == 0 && actual == nil {
+			return nil
+		}
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return []string{path}
+		}
+
+		keys := make([]string, 0, len(e))
+		for k := range e {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var result []string
+		for _, k := range keys {
+			result = append(result, fields(join(path, k), e[k], a[k])...)
+		}
+		return result
+	case []interface{}:
+		if len(e) == 0 && actual == nil {
+			return nil
+		}
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(e) {
+			return []string{path}
+		}
+
+		var result []string
+		for i := range e {
+			result = append(result, fields(fmt.Sprintf("%s[%d]", path, i), e[i], a[i])...)
+		}
+		return result
+	case nil:
+		return nil
+	}
+
+	if !equal(expected, actual) {
+		return []string{path}
+	}
+
+	return nil
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// equal compares two scalars, numbers are compared by value because the
+// decoded JSON numbers may be int64 or float64, and the values which are both
+// quantities are compared as quantities because the API server canonicalizes them,
+// e.g. cpu 0.5 is returned as "500m"
+func equal(expected, actual interface{}) bool {
+	e, eok := toFloat(expected)
+	a, aok := toFloat(actual)
+	if eok && aok {
+		return e == a
+	}
+
+	if reflect.DeepEqual(expected, actual) {
+		return true
+	}
+
+	eq, eok := toQuantity(expected)
+	aq, aok := toQuantity(actual)
+	if eok && aok {
+		return eq.Cmp(aq) == 0
+	}
+
+	return false
+}
+
+func toQuantity(v interface{}) (resource.Quantity, bool) {
+	s, ok := v.(string)
+	if !ok {
+		n, ok := toFloat(v)
+		if !ok {
+			return resource.Quantity{}, false
+		}
+		s = fmt.Sprint(n)
+	}
+
+	q, err := resource.ParseQuantity(s)
+	if err != nil {
+		return resource.Quantity{}, false
+	}
+
+	return q, true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,95 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected map[string]interface{}
+		actual   map[string]interface{}
+		want     []string
+	}{
+		{
+			name:     "equal",
+			expected: map[string]interface{}{"replicas": int64(1)},
+			actual:   map[string]interface{}{"replicas": float64(1)},
+		},
+		{
+			name:     "changed",
+			expected: map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1)}},
+			actual:   map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(2)}},
+			want:     []string{"spec.replicas"},
+		},
+		{
+			name:     "defaulted fields are ignored",
+			expected: map[string]interface{}{"spec": map[string]interface{}{}},
+			actual:   map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1)}},
+		},
+		{
+			name:     "empty map is equal to missing",
+			expected: map[string]interface{}{"nodeSelector": map[string]interface{}{}},
+			actual:   map[string]interface{}{},
+		},
+		{
+			name:     "empty list is equal to missing",
+			expected: map[string]interface{}{"tolerations": []interface{}{}},
+			actual:   map[string]interface{}{},
+		},
+		{
+			name:     "missing map",
+			expected: map[string]interface{}{"nodeSelector": map[string]interface{}{"disk": "ssd"}},
+			actual:   map[string]interface{}{},
+			want:     []string{"nodeSelector"},
+		},
+		{
+			name:     "missing list",
+			expected: map[string]interface{}{"tolerations": []interface{}{"a"}},
+			actual:   map[string]interface{}{},
+			want:     []string{"tolerations"},
+		},
+		{
+			name:     "list length changed",
+			expected: map[string]interface{}{"args": []interface{}{"a"}},
+			actual:   map[string]interface{}{"args": []interface{}{"a", "b"}},
+			want:     []string{"args"},
+		},
+		{
+			name:     "integer quantity",
+			expected: map[string]interface{}{"cpu": int64(1)},
+			actual:   map[string]interface{}{"cpu": "1"},
+		},
+		{
+			name:     "decimal quantity",
+			expected: map[string]interface{}{"cpu": 0.5},
+			actual:   map[string]interface{}{"cpu": "500m"},
+		},
+		{
+			name:     "string quantity",
+			expected: map[string]interface{}{"memory": "1Gi"},
+			actual:   map[string]interface{}{"memory": "1024Mi"},
+		},
+		{
+			name:     "quantity changed",
+			expected: map[string]interface{}{"cpu": "1"},
+			actual:   map[string]interface{}{"cpu": "500m"},
+			want:     []string{"cpu"},
+		},
+		{
+			name:     "string changed",
+			expected: map[string]interface{}{"image": "nginx:1.20"},
+			actual:   map[string]interface{}{"image": "nginx:1.21"},
+			want:     []string{"image"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fields(tt.expected, tt.actual); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
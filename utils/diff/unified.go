@@ -0,0 +1,213 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+const contextLines = 3
+
+type op struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// Unified returns the unified diff of two texts, it is empty if they are the same
+func Unified(from, to, fromName, toName string) string {
+	if from == to {
+		return ""
+	}
+
+	ops := lineOps(splitLines(from), splitLines(to))
+
+	changed := false
+	for _, o := range ops {
+		if o.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+
+	// line numbers of the ops in from and to
+	fromLine, toLine := make([]int, len(ops)), make([]int, len(ops))
+	f, t := 1, 1
+	for i, o := range ops {
+		fromLine[i], toLine[i] = f, t
+		if o.kind != '+' {
+			f++
+		}
+		if o.kind != '-' {
+			t++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// extend the hunk until there are more than 2*contextLines unchanged lines
+		start := i - contextLines
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*contextLines {
+				break
+			}
+		}
+		end += contextLines
+		if end > len(ops)-1 {
+			end = len(ops) - 1
+		}
+
+		var fromCount, toCount int
+		for _, o := range ops[start : end+1] {
+			if o.kind != '+' {
+				fromCount++
+			}
+			if o.kind != '-' {
+				toCount++
+			}
+		}
+
+		// an empty range starts at the line before it
+		fromStart, toStart := fromLine[start], toLine[start]
+		if fromCount == 0 {
+			fromStart--
+		}
+		if toCount == 0 {
+			toStart--
+		}
+
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+		for _, o := range ops[start : end+1] {
+			b.WriteByte(o.kind)
+			b.WriteString(o.line)
+			b.WriteByte('\n')
+		}
+
+		i = end + 1
+	}
+
+	return b.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lineOps computes the edit operations by the longest common subsequence of lines,
+// the common prefix and suffix are skipped and the rest is computed in linear space
+func lineOps(a, b []string) []op {
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+	ops = lcsOps(ops, a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+
+	return ops
+}
+
+// lcsOps appends the edit operations of a and b to ops by Hirschberg's algorithm
+func lcsOps(ops []op, a, b []string) []op {
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			ops = append(ops, op{'+', line})
+		}
+		return ops
+	case len(b) == 0:
+		for _, line := range a {
+			ops = append(ops, op{'-', line})
+		}
+		return ops
+	case len(a) == 1:
+		for j, line := range b {
+			if line == a[0] {
+				ops = lcsOps(ops, nil, b[:j])
+				ops = append(ops, op{' ', line})
+				return lcsOps(ops, nil, b[j+1:])
+			}
+		}
+		ops = append(ops, op{'-', a[0]})
+		return lcsOps(ops, nil, b)
+	}
+
+	// split b where the LCS of the two halves of a is the longest
+	mid := len(a) / 2
+	forward := lcsForward(a[:mid], b)
+	backward := lcsBackward(a[mid:], b)
+	split, longest := 0, -1
+	for j := 0; j <= len(b); j++ {
+		if n := forward[j] + backward[j]; n > longest {
+			split, longest = j, n
+		}
+	}
+
+	ops = lcsOps(ops, a[:mid], b[:split])
+	return lcsOps(ops, a[mid:], b[split:])
+}
+
+// lcsForward returns the LCS lengths of a and every prefix b[:j]
+func lcsForward(a, b []string) []int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else if prev[j+1] >= cur[j] {
+				cur[j+1] = prev[j+1]
+			} else {
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev
+}
+
+// lcsBackward returns the LCS lengths of a and every suffix b[j:]
+func lcsBackward(a, b []string) []int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				cur[j] = prev[j+1] + 1
+			} else if prev[j] >= cur[j+1] {
+				cur[j] = prev[j]
+			} else {
+				cur[j] = cur[j+1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev
+}
@@ -0,0 +1,105 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{
+			name: "equal",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+		},
+		{
+			name: "empty from",
+			to:   "a\nb\n",
+			want: "--- from\n+++ to\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "empty to",
+			from: "a\nb\n",
+			want: "--- from\n+++ to\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "changed line",
+			from: "a\nb\nc\n",
+			to:   "a\nx\nc\n",
+			want: "--- from\n+++ to\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name: "added line",
+			from: "a\nb\n",
+			to:   "a\nx\nb\n",
+			want: "--- from\n+++ to\n@@ -1,2 +1,3 @@\n a\n+x\n b\n",
+		},
+		{
+			name: "hunk context",
+			from: lines(1, 20),
+			to:   strings.Replace(lines(1, 20), "10\n", "x\n", 1),
+			want: "--- from\n+++ to\n@@ -7,7 +7,7 @@\n 7\n 8\n 9\n-10\n+x\n 11\n 12\n 13\n",
+		},
+		{
+			name: "separate hunks",
+			from: lines(1, 20),
+			to:   strings.Replace(strings.Replace(lines(1, 20), "2\n", "x\n", 1), "19\n", "y\n", 1),
+			want: "--- from\n+++ to\n@@ -1,5 +1,5 @@\n 1\n-2\n+x\n 3\n 4\n 5\n" +
+				"@@ -16,5 +16,5 @@\n 16\n 17\n 18\n-19\n+y\n 20\n",
+		},
+		{
+			name: "merged hunks",
+			from: lines(1, 10),
+			to:   strings.Replace(strings.Replace(lines(1, 10), "2\n", "x\n", 1), "8\n", "y\n", 1),
+			want: "--- from\n+++ to\n@@ -1,10 +1,10 @@\n 1\n-2\n+x\n 3\n 4\n 5\n 6\n 7\n-8\n+y\n 9\n 10\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unified(tt.from, tt.to, "from", "to"); got != tt.want {
+				t.Errorf("Unified() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineOps(t *testing.T) {
+	a := strings.Split("a b c a b b a", " ")
+	b := strings.Split("c b a b a c", " ")
+
+	var from, to []string
+	common := 0
+	for _, o := range lineOps(a, b) {
+		if o.kind != '+' {
+			from = append(from, o.line)
+		}
+		if o.kind != '-' {
+			to = append(to, o.line)
+		}
+		if o.kind == ' ' {
+			common++
+		}
+	}
+
+	if strings.Join(from, " ") != strings.Join(a, " ") || strings.Join(to, " ") != strings.Join(b, " ") {
+		t.Errorf("lineOps() does not transform %v to %v", a, b)
+	}
+	if common != 4 {
+		t.Errorf("lineOps() has %d common lines, want 4", common)
+	}
+}
+
+func lines(from, to int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.String()
+}
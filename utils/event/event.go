@@ -0,0 +1,74 @@
+package event
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// dedupRecorder drops the events which were already recorded for the same object within the window,
+// this avoids event spam when a failing object is reconciled again and again
+type dedupRecorder struct {
+	recorder record.EventRecorder
+	window   time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewDedupRecorder(recorder record.EventRecorder, window time.Duration) record.EventRecorder {
+	return &dedupRecorder{
+		recorder: recorder,
+		window:   window,
+		seen:     map[string]time.Time{},
+	}
+}
+
+func (r *dedupRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.duplicated(object, eventtype, reason, message) {
+		return
+	}
+	r.recorder.Event(object, eventtype, reason, message)
+}
+
+func (r *dedupRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *dedupRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if r.duplicated(object, eventtype, reason, message) {
+		return
+	}
+	r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", message)
+}
+
+func (r *dedupRecorder) duplicated(object runtime.Object, eventtype, reason, message string) bool {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return false
+	}
+
+	key := string(accessor.GetUID()) + "/" + eventtype + "/" + reason + "/" + message
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for k, t := range r.seen {
+		if now.Sub(t) > r.window {
+			delete(r.seen, k)
+		}
+	}
+
+	if _, ok := r.seen[key]; ok {
+		return true
+	}
+	r.seen[key] = now
+
+	return false
+}
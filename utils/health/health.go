@@ -0,0 +1,193 @@
+package health
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Check returns whether the object is healthy and a message describing why it is not
+func Check(obj *unstructured.Unstructured) (bool, string, error) {
+	gk := obj.GroupVersionKind().GroupKind()
+
+	switch {
+	case gk.Group == "apps" && gk.Kind == "Deployment":
+		deploy := &appsv1.Deployment{}
+		if err := fromUnstructured(obj, deploy); err != nil {
+			return false, "", err
+		}
+		return deploymentHealth(deploy)
+	case gk.Group == "apps" && gk.Kind == "StatefulSet":
+		sts := &appsv1.StatefulSet{}
+		if err := fromUnstructured(obj, sts); err != nil {
+			return false, "", err
+		}
+		return statefulSetHealth(sts)
+	case gk.Group == "apps" && gk.Kind == "DaemonSet":
+		ds := &appsv1.DaemonSet{}
+		if err := fromUnstructured(obj, ds); err != nil {
+			return false, "", err
+		}
+		return daemonSetHealth(ds)
+	case gk.Group == "batch" && gk.Kind == "Job":
+		job := &batchv1.Job{}
+		if err := fromUnstructured(obj, job); err != nil {
+			return false, "", err
+		}
+		return jobHealth(job)
+	case gk.Group == "" && gk.Kind == "PersistentVolumeClaim":
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := fromUnstructured(obj, pvc); err != nil {
+			return false, "", err
+		}
+		return pvcHealth(pvc)
+	case gk.Group == "" && gk.Kind == "Service":
+		svc := &corev1.Service{}
+		if err := fromUnstructured(obj, svc); err != nil {
+			return false, "", err
+		}
+		return serviceHealth(svc)
+	}
+
+	return readyConditionHealth(obj)
+}
+
+func fromUnstructured(obj *unstructured.Unstructured, into interface{}) error {
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, into)
+}
+
+func replicas(r *int32) int32 {
+	if r == nil {
+		return 1
+	}
+	return *r
+}
+
+func deploymentHealth(deploy *appsv1.Deployment) (bool, string, error) {
+	if deploy.Spec.Paused {
+		return true, "", nil
+	}
+
+	if deploy.Status.ObservedGeneration < deploy.Generation {
+		return false, "waiting for deployment spec update to be observed", nil
+	}
+
+	for _, c := range deploy.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			return false, fmt.Sprintf("deployment exceeded its progress deadline: %s", c.Message), nil
+		}
+	}
+
+	want := replicas(deploy.Spec.Replicas)
+	if deploy.Status.UpdatedReplicas < want {
+		return false, fmt.Sprintf("%d of %d updated replicas are available", deploy.Status.UpdatedReplicas, want), nil
+	}
+	if deploy.Status.Replicas > deploy.Status.UpdatedReplicas {
+		return false, fmt.Sprintf("%d old replicas are pending termination", deploy.Status.Replicas-deploy.Status.UpdatedReplicas), nil
+	}
+	if deploy.Status.AvailableReplicas < want {
+		return false, fmt.Sprintf("%d of %d replicas are available", deploy.Status.AvailableReplicas, want), nil
+	}
+
+	return true, "", nil
+}
+
+func statefulSetHealth(sts *appsv1.StatefulSet) (bool, string, error) {
+	if sts.Status.ObservedGeneration < sts.Generation {
+		return false, "waiting for statefulset spec update to be observed", nil
+	}
+
+	want := replicas(sts.Spec.Replicas)
+	if sts.Status.ReadyReplicas < want {
+		return false, fmt.Sprintf("%d of %d replicas are ready", sts.Status.ReadyReplicas, want), nil
+	}
+
+	if sts.Spec.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType {
+		if sts.Status.UpdateRevision != "" && sts.Status.CurrentRevision != sts.Status.UpdateRevision {
+			return false, fmt.Sprintf("%d of %d replicas are updated", sts.Status.UpdatedReplicas, want), nil
+		}
+	}
+
+	return true, "", nil
+}
+
+func daemonSetHealth(ds *appsv1.DaemonSet) (bool, string, error) {
+	if ds.Status.ObservedGeneration < ds.Generation {
+		return false, "waiting for daemonset spec update to be observed", nil
+	}
+
+	want := ds.Status.DesiredNumberScheduled
+	if ds.Spec.UpdateStrategy.Type == appsv1.RollingUpdateDaemonSetStrategyType && ds.Status.UpdatedNumberScheduled < want {
+		return false, fmt.Sprintf("%d of %d pods are updated", ds.Status.UpdatedNumberScheduled, want), nil
+	}
+	if ds.Status.NumberAvailable < want {
+		return false, fmt.Sprintf("%d of %d pods are available", ds.Status.NumberAvailable, want), nil
+	}
+
+	return true, "", nil
+}
+
+func jobHealth(job *batchv1.Job) (bool, string, error) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, "", nil
+		case batchv1.JobFailed:
+			return false, fmt.Sprintf("job failed: %s", c.Message), nil
+		}
+	}
+
+	return false, "waiting for job to complete", nil
+}
+
+func pvcHealth(pvc *corev1.PersistentVolumeClaim) (bool, string, error) {
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return false, fmt.Sprintf("persistentvolumeclaim is %s", pvc.Status.Phase), nil
+	}
+
+	return true, "", nil
+}
+
+func serviceHealth(svc *corev1.Service) (bool, string, error) {
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return true, "", nil
+	}
+
+	if len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return false, "waiting for load balancer ingress", nil
+	}
+
+	return true, "", nil
+}
+
+// readyConditionHealth checks the Ready condition which is widely used by custom resources,
+// the object is treated as healthy if it has no such condition
+func readyConditionHealth(obj *unstructured.Unstructured) (bool, string, error) {
+	conditions, found, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil || !found {
+		return true, "", nil
+	}
+
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+
+		if cond["status"] == "True" {
+			return true, "", nil
+		}
+
+		msg, _ := cond["message"].(string)
+		return false, fmt.Sprintf("not ready: %s", msg), nil
+	}
+
+	return true, "", nil
+}
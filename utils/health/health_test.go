@@ -0,0 +1,184 @@
+package health
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func object(apiVersion, kind string, generation int64, spec, status map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":       "test",
+			"generation": generation,
+		},
+	}}
+	if spec != nil {
+		obj.Object["spec"] = spec
+	}
+	if status != nil {
+		obj.Object["status"] = status
+	}
+
+	return obj
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		obj     *unstructured.Unstructured
+		healthy bool
+		msg     string
+	}{
+		{
+			name: "deployment available",
+			obj: object("apps/v1", "Deployment", 2, map[string]interface{}{"replicas": int64(2)}, map[string]interface{}{
+				"observedGeneration": int64(2), "replicas": int64(2), "updatedReplicas": int64(2), "availableReplicas": int64(2),
+			}),
+			healthy: true,
+		},
+		{
+			name: "deployment spec not observed",
+			obj: object("apps/v1", "Deployment", 2, map[string]interface{}{"replicas": int64(2)}, map[string]interface{}{
+				"observedGeneration": int64(1), "replicas": int64(2), "updatedReplicas": int64(2), "availableReplicas": int64(2),
+			}),
+			msg: "waiting for deployment spec update to be observed",
+		},
+		{
+			name: "deployment with old replicas",
+			obj: object("apps/v1", "Deployment", 2, map[string]interface{}{"replicas": int64(2)}, map[string]interface{}{
+				"observedGeneration": int64(2), "replicas": int64(3), "updatedReplicas": int64(2), "availableReplicas": int64(2),
+			}),
+			msg: "1 old replicas are pending termination",
+		},
+		{
+			name: "deployment progress deadline exceeded",
+			obj: object("apps/v1", "Deployment", 1, nil, map[string]interface{}{
+				"observedGeneration": int64(1),
+				"conditions": []interface{}{map[string]interface{}{
+					"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded", "message": "timed out",
+				}},
+			}),
+			msg: "deployment exceeded its progress deadline: timed out",
+		},
+		{
+			name:    "deployment paused",
+			obj:     object("apps/v1", "Deployment", 2, map[string]interface{}{"paused": true}, nil),
+			healthy: true,
+		},
+		{
+			name: "statefulset ready",
+			obj: object("apps/v1", "StatefulSet", 1, map[string]interface{}{
+				"replicas": int64(2), "updateStrategy": map[string]interface{}{"type": "RollingUpdate"},
+			}, map[string]interface{}{
+				"observedGeneration": int64(1), "readyReplicas": int64(2), "currentRevision": "v2", "updateRevision": "v2",
+			}),
+			healthy: true,
+		},
+		{
+			name: "statefulset mid-rollout",
+			obj: object("apps/v1", "StatefulSet", 1, map[string]interface{}{
+				"replicas": int64(2), "updateStrategy": map[string]interface{}{"type": "RollingUpdate"},
+			}, map[string]interface{}{
+				"observedGeneration": int64(1), "readyReplicas": int64(2), "updatedReplicas": int64(1),
+				"currentRevision": "v1", "updateRevision": "v2",
+			}),
+			msg: "1 of 2 replicas are updated",
+		},
+		{
+			name: "statefulset not ready",
+			obj: object("apps/v1", "StatefulSet", 1, map[string]interface{}{"replicas": int64(3)}, map[string]interface{}{
+				"observedGeneration": int64(1), "readyReplicas": int64(1),
+			}),
+			msg: "1 of 3 replicas are ready",
+		},
+		{
+			name: "daemonset updating",
+			obj: object("apps/v1", "DaemonSet", 1, map[string]interface{}{
+				"updateStrategy": map[string]interface{}{"type": "RollingUpdate"},
+			}, map[string]interface{}{
+				"observedGeneration": int64(1), "desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(2), "numberAvailable": int64(3),
+			}),
+			msg: "2 of 3 pods are updated",
+		},
+		{
+			name: "job complete",
+			obj: object("batch/v1", "Job", 1, nil, map[string]interface{}{
+				"conditions": []interface{}{map[string]interface{}{"type": "Complete", "status": "True"}},
+			}),
+			healthy: true,
+		},
+		{
+			name: "job failed",
+			obj: object("batch/v1", "Job", 1, nil, map[string]interface{}{
+				"conditions": []interface{}{map[string]interface{}{"type": "Failed", "status": "True", "message": "backoff limit exceeded"}},
+			}),
+			msg: "job failed: backoff limit exceeded",
+		},
+		{
+			name: "job running",
+			obj:  object("batch/v1", "Job", 1, nil, nil),
+			msg:  "waiting for job to complete",
+		},
+		{
+			name: "pvc pending",
+			obj:  object("v1", "PersistentVolumeClaim", 1, nil, map[string]interface{}{"phase": "Pending"}),
+			msg:  "persistentvolumeclaim is Pending",
+		},
+		{
+			name:    "pvc bound",
+			obj:     object("v1", "PersistentVolumeClaim", 1, nil, map[string]interface{}{"phase": "Bound"}),
+			healthy: true,
+		},
+		{
+			name:    "cluster ip service",
+			obj:     object("v1", "Service", 1, map[string]interface{}{"type": "ClusterIP"}, nil),
+			healthy: true,
+		},
+		{
+			name: "load balancer without ingress",
+			obj:  object("v1", "Service", 1, map[string]interface{}{"type": "LoadBalancer"}, nil),
+			msg:  "waiting for load balancer ingress",
+		},
+		{
+			name: "load balancer with ingress",
+			obj: object("v1", "Service", 1, map[string]interface{}{"type": "LoadBalancer"}, map[string]interface{}{
+				"loadBalancer": map[string]interface{}{"ingress": []interface{}{map[string]interface{}{"ip": "10.0.0.1"}}},
+			}),
+			healthy: true,
+		},
+		{
+			name:    "custom resource without conditions",
+			obj:     object("example.com/v1", "Database", 1, nil, map[string]interface{}{}),
+			healthy: true,
+		},
+		{
+			name: "custom resource without ready condition",
+			obj: object("example.com/v1", "Database", 1, nil, map[string]interface{}{
+				"conditions": []interface{}{map[string]interface{}{"type": "Synced", "status": "False"}},
+			}),
+			healthy: true,
+		},
+		{
+			name: "custom resource not ready",
+			obj: object("example.com/v1", "Database", 1, nil, map[string]interface{}{
+				"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "False", "message": "provisioning"}},
+			}),
+			msg: "not ready: provisioning",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			healthy, msg, err := Check(tt.obj)
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if healthy != tt.healthy || msg != tt.msg {
+				t.Errorf("Check() = %v, %q, want %v, %q", healthy, msg, tt.healthy, tt.msg)
+			}
+		})
+	}
+}
@@ -13,7 +13,8 @@ import (
 	"sigs.k8s.io/yaml"
 )
 
-func getChart(path string) (*chart.Chart, error) {
+// GetChart locates and loads the Helm chart from path
+func GetChart(path string) (*chart.Chart, error) {
 	client := action.NewInstall(&action.Configuration{})
 	settings := cli.New()
 	cp, err := client.ChartPathOptions.LocateChart(path, settings)
@@ -48,13 +49,18 @@ func getValues(name, namespace string, bytes []byte, chart *chart.Chart) (chartu
 }
 
 func GetManifests(name, namespace, path string, bytes []byte) ([][]byte, error) {
-	var result [][]byte
-
-	chart, err := getChart(path)
+	chart, err := GetChart(path)
 	if err != nil {
 		return nil, err
 	}
 
+	return RenderManifests(name, namespace, chart, bytes)
+}
+
+// RenderManifests renders the chart with values, the CRDs are placed in the front
+func RenderManifests(name, namespace string, chart *chart.Chart, bytes []byte) ([][]byte, error) {
+	var result [][]byte
+
 	for _, crd := range chart.CRDObjects() {
 		result = append(result, crd.File.Data)
 	}
@@ -1,5 +1,15 @@
 package utils
 
+import "strconv"
+
 func ManifestsSecretName(n, ns string) string {
 	return ns + "-" + n + "-" + "manifests"
 }
+
+func RevisionSecretName(n string, revision int64) string {
+	return "helmchart." + n + ".v" + strconv.FormatInt(revision, 10)
+}
+
+func PlanConfigMapName(n string) string {
+	return "helmchart." + n + ".plan"
+}
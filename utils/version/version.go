@@ -0,0 +1,5 @@
+package version
+
+// Version is the version of the operator, it is set at build time with
+// -ldflags "-X github.com/chenzhiwei/helm-operator/utils/version.Version=<version>"
+var Version = "dev"